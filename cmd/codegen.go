@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/cedws/w101-client-go/codegen"
+	"github.com/spf13/cobra"
+)
+
+var codegenCmd = &cobra.Command{
+	Use:   "codegen <file|dir>",
+	Short: "Generate Go code for a protocol definition, reading from stdin if file is -",
+	Long: `Generate Go code for a protocol definition, reading from stdin if file is -.
+
+If given a directory, a file is generated for every XML definition in it and written to the
+output directory. Every definition is attempted and failures are reported at the end. --dml
+isn't supported for directories.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		packageName, err := cmd.Flags().GetString("package")
+		if err != nil {
+			return err
+		}
+		if packageName == "" {
+			if packageName = os.Getenv("GOPACKAGE"); packageName == "" {
+				return codegen.ErrNoGoPackageEnv
+			}
+		}
+
+		jsonTags, err := cmd.Flags().GetBool("json-tags")
+		if err != nil {
+			return err
+		}
+
+		var opts []codegen.GenerateOption
+		if jsonTags {
+			opts = append(opts, codegen.WithJSONTags())
+		}
+
+		binary, err := cmd.Flags().GetBool("dml")
+		if err != nil {
+			return err
+		}
+
+		if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+			// Directories are globbed for XML definitions only
+			if binary {
+				return errors.New("--dml can't be used with a directory")
+			}
+
+			outDir, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			return codegen.GenerateDir(args[0], outDir, packageName, opts...)
+		}
+
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			in = file
+		}
+
+		read := codegen.ReadProtocolFrom
+		if binary {
+			read = func(r io.Reader) (codegen.Protocol, error) {
+				return codegen.ReadProtocolDML(r)
+			}
+		}
+
+		pr, err := read(in)
+		if err != nil {
+			return err
+		}
+
+		return codegen.Generate(os.Stdout, packageName, pr, opts...)
+	},
+}
+
+func init() {
+	codegenCmd.Flags().StringP("package", "p", "", "package name of the generated code, $GOPACKAGE if unset")
+	codegenCmd.Flags().Bool("dml", false, "read a single definition as binary DML rather than XML")
+	codegenCmd.Flags().StringP("output", "o", ".", "directory to write to when generating a directory of definitions")
+	codegenCmd.Flags().Bool("json-tags", false, "add json tags to message fields using their protocol definition names")
+	rootCmd.AddCommand(codegenCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/cedws/w101-client-go/dml"
+	"github.com/spf13/cobra"
+)
+
+var dmlCmd = &cobra.Command{
+	Use:   "dml",
+	Short: "Inspect DML tables",
+}
+
+var dmlToJSONCmd = &cobra.Command{
+	Use:   "tojson <file>",
+	Short: "Print the tables in a file as JSON",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		tables, err := dml.DecodeTable(file)
+		if err != nil {
+			return err
+		}
+
+		return dml.TablesToJSON(os.Stdout, tables)
+	},
+}
+
+func init() {
+	dmlCmd.AddCommand(dmlToJSONCmd)
+	rootCmd.AddCommand(dmlCmd)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cedws/w101-client-go/wad"
+	"github.com/spf13/cobra"
+)
+
+const progressBarWidth = 30
+
+var wadCmd = &cobra.Command{
+	Use:   "wad",
+	Short: "Inspect and extract WAD archives",
+}
+
+var wadExtractCmd = &cobra.Command{
+	Use:   "extract <archive>",
+	Short: "Extract every entry in an archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outDir, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		archive, err := wad.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		progress := func(done, total int, current string) {
+			printProgress(os.Stderr, done, total, current)
+		}
+
+		return archive.ExtractAll(outDir, wad.WithProgress(progress))
+	},
+}
+
+var wadListCmd = &cobra.Command{
+	Use:   "list <archive>",
+	Short: "List the entries in an archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archive, err := wad.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH\tSIZE\tCOMPRESSED SIZE\tCHECKSUM")
+
+		for entry := range archive.Entries() {
+			compSize := "-"
+			if entry.Compressed {
+				compSize = fmt.Sprint(entry.CompSize)
+			}
+
+			fmt.Fprintf(w, "%v\t%v\t%v\t%08x\n", entry.Path, entry.Size, compSize, entry.Checksum)
+		}
+
+		return w.Flush()
+	},
+}
+
+var wadInfoCmd = &cobra.Command{
+	Use:   "info <archive>",
+	Short: "Print a summary of an archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archive, err := wad.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		stats := archive.Stats()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Entries:\t%v\n", stats.Entries)
+		fmt.Fprintf(w, "Compressed:\t%v\n", stats.Compressed)
+		fmt.Fprintf(w, "Stored:\t%v\n", stats.Stored)
+		fmt.Fprintf(w, "Size:\t%v\n", stats.Size)
+		fmt.Fprintf(w, "Compressed size:\t%v\n", stats.CompSize)
+		fmt.Fprintf(w, "Ratio:\t%.2f\n", stats.Ratio())
+
+		return w.Flush()
+	},
+}
+
+func printProgress(w io.Writer, done, total int, current string) {
+	filled := progressBarWidth
+	if total > 0 {
+		filled = done * progressBarWidth / total
+	}
+
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(w, "\r\033[K[%v] %v/%v %v", bar, done, total, current)
+
+	if done == total {
+		fmt.Fprintln(w)
+	}
+}
+
+func init() {
+	wadExtractCmd.Flags().StringP("output", "o", ".", "directory to extract into")
+
+	wadCmd.AddCommand(wadExtractCmd)
+	wadCmd.AddCommand(wadListCmd)
+	wadCmd.AddCommand(wadInfoCmd)
+	rootCmd.AddCommand(wadCmd)
+}
@@ -0,0 +1,121 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cedws/w101-client-go/dml"
+)
+
+// binaryTypes maps the field types of binary DML to their names in XML definitions
+var binaryTypes = map[uint8]DMLType{
+	dml.GID:   dmlGid,
+	dml.INT:   dmlInt,
+	dml.UINT:  dmlUint,
+	dml.FLT:   dmlFlt,
+	dml.BYT:   dmlByt,
+	dml.UBYT:  dmlUbyt,
+	dml.USHRT: dmlUshrt,
+	dml.DBL:   dmlDbl,
+	dml.STR:   dmlStr,
+	dml.WSTR:  dmlWstr,
+	dml.SHRT:  dmlShrt,
+}
+
+// ReadProtocolDML reads a protocol definition in the binary DML format from r
+func ReadProtocolDML(r io.Reader, opts ...dml.DecodeOption) (Protocol, error) {
+	tables, err := dml.DecodeTable(r, opts...)
+	if err != nil {
+		return Protocol{}, err
+	}
+
+	return ProtocolFromTables(tables)
+}
+
+// ProtocolFromTables builds a protocol from tables decoded from the binary DML format. The
+// _ProtocolInfo table describes the protocol and every other table is a message. Fields starting
+// with an underscore describe the message rather than being sent with it.
+func ProtocolFromTables(tables []dml.Table) (Protocol, error) {
+	var pr Protocol
+
+	info, ok := dml.FindTable(tables, "_ProtocolInfo")
+	if !ok || len(info.Records) == 0 {
+		return Protocol{}, ErrMissingProtocolInfo
+	}
+
+	record := info.Records[0]
+	for _, name := range []string{"ServiceID", "ProtocolType", "ProtocolVersion", "ProtocolDescription"} {
+		if _, ok := record[name]; !ok {
+			return Protocol{}, ErrMissingProtocolInfo
+		}
+	}
+
+	pr.Meta.ServiceID = fmt.Sprint(record["ServiceID"])
+	pr.Meta.Type = fmt.Sprint(record["ProtocolType"])
+	pr.Meta.Version = fmt.Sprint(record["ProtocolVersion"])
+	pr.Meta.Description = fmt.Sprint(record["ProtocolDescription"])
+	pr.Service = serviceName(pr.Meta.Type)
+
+	for _, table := range tables {
+		if table.Name == "_ProtocolInfo" {
+			continue
+		}
+
+		msg, err := messageFromTable(table)
+		if err != nil {
+			return Protocol{}, err
+		}
+
+		pr.Messages = append(pr.Messages, msg)
+	}
+
+	orderMessages(&pr)
+
+	return pr, nil
+}
+
+func messageFromTable(table dml.Table) (Message, error) {
+	if len(table.Records) == 0 {
+		return Message{}, fmt.Errorf("%w: table %v has no records", ErrInvalidRecord, table.Name)
+	}
+
+	record := table.Records[0]
+	msg := Message{Tag: table.Name}
+
+	var ok bool
+	if msg.Meta.MsgName, ok = record.String("_MsgName"); !ok {
+		return Message{}, fmt.Errorf("%w: table %v has no _MsgName", ErrInvalidRecord, table.Name)
+	}
+	if msg.Meta.MsgDescription, ok = record.String("_MsgDescription"); !ok {
+		return Message{}, fmt.Errorf("%w: table %v has no _MsgDescription", ErrInvalidRecord, table.Name)
+	}
+	if msg.Meta.MsgHandler, ok = record.String("_MsgHandler"); !ok {
+		return Message{}, fmt.Errorf("%w: table %v has no _MsgHandler", ErrInvalidRecord, table.Name)
+	}
+	msg.Type = messageType(msg.Meta.MsgHandler)
+
+	// MsgOrder is optional
+	if order, ok := record.Int("_MsgOrder"); ok {
+		msg.Meta.MsgOrder = int(order)
+	}
+
+	for _, field := range table.Fields {
+		if strings.HasPrefix(field.Name, "_") {
+			continue
+		}
+
+		dmlType, ok := binaryTypes[field.Type]
+		if !ok {
+			return Message{}, fmt.Errorf("%w: invalid type %v", ErrInvalidMessage, field.Type)
+		}
+
+		msg.Fields = append(msg.Fields, Field{
+			Name:    titleCaserNoLower.String(field.Name),
+			Type:    dmlType,
+			DMLName: field.Name,
+		})
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,97 @@
+package codegen
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cedws/w101-client-go/dml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func messageTable(tag, handler, description string, order uint8, fields ...dml.RecordField) dml.Table {
+	record := dml.Record{
+		"_MsgName":        tag,
+		"_MsgDescription": description,
+		"_MsgHandler":     handler,
+		"_MsgOrder":       order,
+	}
+
+	metaFields := []dml.RecordField{
+		{Name: "_MsgName", Type: dml.STR},
+		{Name: "_MsgDescription", Type: dml.STR},
+		{Name: "_MsgHandler", Type: dml.STR},
+		{Name: "_MsgOrder", Type: dml.UBYT},
+	}
+
+	for _, field := range fields {
+		switch field.Type {
+		case dml.STR, dml.WSTR:
+			record[field.Name] = ""
+		case dml.UINT:
+			record[field.Name] = uint32(0)
+		case dml.UBYT:
+			record[field.Name] = uint8(0)
+		case dml.GID:
+			record[field.Name] = uint64(0)
+		}
+	}
+
+	return dml.Table{
+		Name:    tag,
+		Fields:  append(metaFields, fields...),
+		Records: []dml.Record{record},
+	}
+}
+
+func TestReadProtocolDML(t *testing.T) {
+	tables := []dml.Table{
+		{
+			Name: "_ProtocolInfo",
+			Fields: []dml.RecordField{
+				{Name: "ServiceID", Type: dml.UBYT},
+				{Name: "ProtocolType", Type: dml.STR},
+				{Name: "ProtocolVersion", Type: dml.INT},
+				{Name: "ProtocolDescription", Type: dml.STR},
+			},
+			Records: []dml.Record{{
+				"ServiceID":           uint8(99),
+				"ProtocolType":        "TEST",
+				"ProtocolVersion":     int32(1),
+				"ProtocolDescription": "Test Messages",
+			}},
+		},
+		messageTable("MSG_PONG", "MSG_Pong", "Answer a ping.\nSent in response to every ping.", 2,
+			dml.RecordField{Name: "Sequence", Type: dml.UINT},
+			dml.RecordField{Name: "ServerTime", Type: dml.GID},
+		),
+		messageTable("MSG_PING", "MSG_Ping", "Ping the server", 1,
+			dml.RecordField{Name: "Sequence", Type: dml.UINT},
+			dml.RecordField{Name: "Note", Type: dml.STR},
+			dml.RecordField{Name: "Greeting", Type: dml.WSTR},
+			dml.RecordField{Name: "Flag", Type: dml.UBYT},
+			dml.RecordField{Name: "latency", Type: dml.UINT},
+		),
+		messageTable("MSG_DISCONNECT", "MSG_Disconnect", "Disconnect from the server", 3),
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, dml.EncodeTable(&b, tables))
+
+	pr, err := ReadProtocolDML(&b)
+	require.NoError(t, err)
+
+	expected, err := ReadProtocol("testdata/test.xml")
+	require.NoError(t, err)
+
+	// Binary DML has no BOOL type, so the XML's Healthy field can't be represented
+	expected.Messages[1].Fields = expected.Messages[1].Fields[:2]
+	expected.Messages[1].Meta.MsgDescription = "Answer a ping.\nSent in response to every ping."
+
+	assert.Equal(t, expected, pr)
+}
+
+func TestProtocolFromTablesMissingInfo(t *testing.T) {
+	_, err := ProtocolFromTables([]dml.Table{messageTable("MSG_PING", "MSG_Ping", "", 1)})
+	assert.Equal(t, ErrMissingProtocolInfo, err)
+}
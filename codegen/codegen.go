@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"go/format"
 	"io"
+	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -85,6 +87,22 @@ var (
 type Field struct {
 	Name string
 	Type DMLType
+	// DMLName is the name of the field as it's spelled in the protocol definition, before Name is
+	// title cased and made a valid and unique Go identifier. Name is used if it's empty.
+	DMLName string
+}
+
+type GenerateOption func(*generateOptions)
+
+type generateOptions struct {
+	jsonTags bool
+}
+
+// WithJSONTags adds a json tag to every message field, naming it as in the protocol definition.
+func WithJSONTags() GenerateOption {
+	return func(o *generateOptions) {
+		o.jsonTags = true
+	}
 }
 
 type Message struct {
@@ -126,6 +144,22 @@ func ReadProtocol(file string) (Protocol, error) {
 	return pr, nil
 }
 
+// ReadProtocolFrom reads a protocol definition from r
+func ReadProtocolFrom(r io.Reader) (Protocol, error) {
+	doc := etree.NewDocument()
+
+	if _, err := doc.ReadFrom(r); err != nil {
+		return Protocol{}, err
+	}
+
+	var pr Protocol
+	if err := readProtocol(doc, &pr); err != nil {
+		return Protocol{}, err
+	}
+
+	return pr, nil
+}
+
 func UnmarshalProtocol(data []byte) (Protocol, error) {
 	doc := etree.NewDocument()
 
@@ -141,7 +175,12 @@ func UnmarshalProtocol(data []byte) (Protocol, error) {
 	return pr, nil
 }
 
-func Generate(w io.Writer, packageName string, pr Protocol) error {
+func Generate(w io.Writer, packageName string, pr Protocol, opts ...GenerateOption) error {
+	var options generateOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	var b bytes.Buffer
 
 	importBytes := func() bool {
@@ -169,7 +208,7 @@ func Generate(w io.Writer, packageName string, pr Protocol) error {
 	importBinary := func() bool {
 		for _, msg := range pr.Messages {
 			for _, field := range msg.Fields {
-				if dmlStringType(field.Type) {
+				if !dmlStringType(field.Type) {
 					return true
 				}
 			}
@@ -188,12 +227,28 @@ func Generate(w io.Writer, packageName string, pr Protocol) error {
 	if importCodegen() {
 		p(&b, `"`, "github.com/cedws/w101-client-go/codegen", `"`)
 	}
+	if len(pr.Messages) > 0 {
+		p(&b, `"fmt"`)
+	}
 	if importBinary() {
 		p(&b, `"encoding/binary"`)
 	}
 	p(&b, `"`, "github.com/cedws/w101-client-go/proto", `"`)
 	p(&b, ")")
 
+	comment(&b, pr.Meta.Description)
+	p(&b, "const ServiceID = ", pr.Meta.ServiceID)
+
+	p(&b)
+
+	p(&b, "const (")
+	for _, msg := range pr.Messages {
+		p(&b, orderConst(msg), " = ", fmt.Sprint(msg.Meta.MsgOrder))
+	}
+	p(&b, ")")
+
+	p(&b)
+
 	p(&b, "type service interface {")
 	for _, msg := range pr.Messages {
 		p(&b, msg.Type, "(", msg.Type, ")")
@@ -210,12 +265,29 @@ func Generate(w io.Writer, packageName string, pr Protocol) error {
 
 	p(&b, "func RegisterService(r *proto.MessageRouter, s service) {")
 	for _, msg := range pr.Messages {
-		p(&b, "proto.RegisterMessageHandler(r, ", pr.Meta.ServiceID, ",", fmt.Sprint(msg.Meta.MsgOrder), ",", "s.", msg.Type, ")")
+		p(&b, "proto.RegisterMessageHandler(r, ServiceID, ", orderConst(msg), ", s.", msg.Type, ")")
 	}
 	p(&b, "}")
 
 	p(&b)
 
+	p(&b, "func Register(r *proto.MessageRouter, h Handlers) {")
+	for _, msg := range pr.Messages {
+		p(&b, "if h.", msg.Type, " != nil {")
+		p(&b, "proto.RegisterMessageHandler(r, ServiceID, ", orderConst(msg), ", h.", msg.Type, ")")
+		p(&b, "}")
+	}
+	p(&b, "}")
+
+	for _, msg := range pr.Messages {
+		p(&b)
+		p(&b, "func Register", msg.Type, "Handler(r *proto.MessageRouter, handler func(", msg.Type, ")) proto.HandlerID {")
+		p(&b, "return proto.RegisterMessageHandler(r, ServiceID, ", orderConst(msg), ", handler)")
+		p(&b, "}")
+	}
+
+	p(&b)
+
 	p(&b, "func NewClient(c *proto.Client) Client {")
 	p(&b, "return Client{c}")
 	p(&b, "}")
@@ -223,13 +295,13 @@ func Generate(w io.Writer, packageName string, pr Protocol) error {
 	for _, msg := range pr.Messages {
 		p(&b)
 		p(&b, "func (c Client) ", msg.Type, "(m *", msg.Type, ") error {")
-		p(&b, "return c.c.WriteMessage(", pr.Meta.ServiceID, ",", fmt.Sprint(msg.Meta.MsgOrder), ", m)")
+		p(&b, "return c.c.WriteMessage(ServiceID, ", orderConst(msg), ", m)")
 		p(&b, "}")
 	}
 
 	p(&b)
 
-	generateStructs(&b, pr.Messages)
+	generateStructs(&b, pr, options)
 
 	if err := reformat(&b, w); err != nil {
 		io.Copy(w, &b)
@@ -239,6 +311,56 @@ func Generate(w io.Writer, packageName string, pr Protocol) error {
 	return nil
 }
 
+// GenerateDir generates code for every protocol definition (*.xml) in inDir, writing a file
+// named after each protocol's service to outDir. It carries on past definitions that fail to
+// generate and returns their errors joined.
+func GenerateDir(inDir, outDir, packageName string, opts ...GenerateOption) error {
+	paths, err := filepath.Glob(filepath.Join(inDir, "*.xml"))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	// Service file names already written, to catch definitions for the same service
+	written := make(map[string]string)
+
+	for _, path := range paths {
+		name, err := generateFile(path, outDir, packageName, written, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", path, err))
+			continue
+		}
+
+		written[name] = path
+	}
+
+	return errors.Join(errs...)
+}
+
+func generateFile(path, outDir, packageName string, written map[string]string, opts []GenerateOption) (string, error) {
+	pr, err := ReadProtocol(path)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.ToLower(pr.Service) + ".go"
+	if other, ok := written[name]; ok {
+		return "", fmt.Errorf("service %v was already generated from %v", pr.Service, other)
+	}
+
+	var b bytes.Buffer
+	if err := Generate(&b, packageName, pr, opts...); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(outDir, name), b.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
 func parseDMLType(dmlType string) (DMLType, bool) {
 	if d := DMLType(dmlType); slices.Contains(dmlTypes, d) {
 		return d, true
@@ -284,6 +406,11 @@ func dmlTypeSize(dmlType DMLType) int {
 	}
 }
 
+// orderConst returns the name of the constant holding the order of msg
+func orderConst(msg Message) string {
+	return "Order" + msg.Type
+}
+
 func unexport(s string) string {
 	if len(s) == 0 {
 		return ""
@@ -291,6 +418,24 @@ func unexport(s string) string {
 	return strings.ToLower(s[:1]) + s[1:]
 }
 
+// comment writes text as a line comment, one line of comment for each line of text
+func comment(w io.Writer, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			p(w, "//")
+			continue
+		}
+
+		p(w, "// ", line)
+	}
+}
+
 func pf(w io.Writer, args ...string) {
 	fmt.Fprintf(w, strings.Join(args, ""))
 }
@@ -318,11 +463,12 @@ func msgBaseSize(msg Message) (size int) {
 }
 
 func compareFieldSize(a, b Field) int {
-	if dmlStringType(a.Type) {
+	switch aString, bString := dmlStringType(a.Type), dmlStringType(b.Type); {
+	case aString && bString:
+		return 0
+	case aString:
 		return -1
-	}
-
-	if dmlStringType(b.Type) {
+	case bString:
 		return 1
 	}
 
@@ -332,7 +478,8 @@ func compareFieldSize(a, b Field) int {
 	return cmp.Compare(sizeA, sizeB) * -1
 }
 
-func generateStructs(b io.Writer, msgs []Message) {
+func generateStructs(b io.Writer, pr Protocol, opts generateOptions) {
+	comment(b, pr.Meta.Description)
 	p(b, "type Service struct {")
 	p(b, "service")
 	p(b, "}")
@@ -343,19 +490,30 @@ func generateStructs(b io.Writer, msgs []Message) {
 	p(b, "c *proto.Client")
 	p(b, "}")
 
-	for _, msg := range msgs {
-		generateStruct(b, msg)
+	p(b)
+
+	p(b, "// Handlers holds a handler for each message, nil handlers aren't registered by Register")
+	p(b, "type Handlers struct {")
+	for _, msg := range pr.Messages {
+		p(b, msg.Type, " func(", msg.Type, ")")
+	}
+	p(b, "}")
+
+	for _, msg := range pr.Messages {
+		generateStruct(b, msg, opts)
 	}
 }
 
-func generateStruct(b io.Writer, msg Message) {
+func generateStruct(b io.Writer, msg Message, opts generateOptions) {
+	p(b)
+	comment(b, msg.Meta.MsgDescription)
 	p(b, "type ", msg.Type, " struct {")
 
 	fields := make([]Field, len(msg.Fields))
 	copy(fields, msg.Fields)
 
 	// Sort fields by size to optimise alignment, strings come first
-	slices.SortFunc(fields, compareFieldSize)
+	slices.SortStableFunc(fields, compareFieldSize)
 
 	for _, field := range fields {
 		goType, ok := dmlAsGoTypes[field.Type]
@@ -363,13 +521,60 @@ func generateStruct(b io.Writer, msg Message) {
 			panic(fmt.Sprintf("codegen: unknown field type %v", field.Type))
 		}
 
-		p(b, field.Name, " ", string(goType))
+		if opts.jsonTags {
+			p(b, field.Name, " ", string(goType), " `json:", strconv.Quote(field.DMLName), "`")
+		} else {
+			p(b, field.Name, " ", string(goType))
+		}
 	}
 	p(b, "}")
 
+	generateRoute(b, msg)
+	p(b)
 	generateMarshal(b, msg)
 	p(b)
 	generateUnmarshal(b, msg)
+	p(b)
+	generateString(b, msg)
+}
+
+// generateRoute implements proto.RoutableMessage so the message can be sent with Client.Send
+func generateRoute(b io.Writer, msg Message) {
+	p(b, "func (s *", msg.Type, ") Service() byte {")
+	p(b, "return ServiceID")
+	p(b, "}")
+	p(b)
+	p(b, "func (s *", msg.Type, ") Order() byte {")
+	p(b, "return ", orderConst(msg))
+	p(b, "}")
+}
+
+func generateString(b io.Writer, msg Message) {
+	p(b, "func (s ", msg.Type, ") String() string {")
+
+	if len(msg.Fields) == 0 {
+		p(b, "return ", strconv.Quote(msg.Type+"{}"))
+		p(b, "}")
+
+		return
+	}
+
+	verbs := make([]string, len(msg.Fields))
+	args := make([]string, len(msg.Fields))
+	for i, field := range msg.Fields {
+		// Quote strings so that empty strings and control characters are visible
+		verb := "%v"
+		if dmlStringType(field.Type) {
+			verb = "%q"
+		}
+
+		verbs[i] = field.Name + ": " + verb
+		args[i] = "s." + field.Name
+	}
+
+	format := msg.Type + "{" + strings.Join(verbs, ", ") + "}"
+	p(b, "return fmt.Sprintf(", strconv.Quote(format), ", ", strings.Join(args, ", "), ")")
+	p(b, "}")
 }
 
 func generateMarshal(b io.Writer, msg Message) {
@@ -386,16 +591,23 @@ func generateMarshal(b io.Writer, msg Message) {
 
 	pf(b, "b := bytes.NewBuffer(make([]byte, 0, ", fmt.Sprint(baseSize))
 	for _, field := range msg.Fields {
-		if dmlStringType(field.Type) {
+		switch field.Type {
+		case dmlStr:
 			pf(b, "+len(s.", field.Name, ")")
+		case dmlWstr:
+			// Each byte of UTF-8 becomes at most one UTF-16 code unit
+			pf(b, "+2*len(s.", field.Name, ")")
 		}
 	}
 	p(b, "))")
 
 	for _, field := range msg.Fields {
-		if dmlStringType(field.Type) {
+		switch field.Type {
+		case dmlStr:
 			p(b, "codegen.WriteString(b, s.", field.Name, ")")
-		} else {
+		case dmlWstr:
+			p(b, "codegen.WriteWString(b, s.", field.Name, ")")
+		default:
 			p(b, "binary.Write(b, binary.LittleEndian, s.", field.Name, ")")
 		}
 	}
@@ -418,11 +630,16 @@ func generateUnmarshal(b io.Writer, msg Message) {
 	p(b, "var err error")
 
 	for _, field := range msg.Fields {
-		if dmlStringType(field.Type) {
+		switch field.Type {
+		case dmlStr:
 			p(b, "if s.", field.Name, ", err = codegen.ReadString(b); err != nil {")
 			p(b, "return err")
 			p(b, "}")
-		} else {
+		case dmlWstr:
+			p(b, "if s.", field.Name, ", err = codegen.ReadWString(b); err != nil {")
+			p(b, "return err")
+			p(b, "}")
+		default:
 			p(b, "if err = binary.Read(b, binary.LittleEndian, &s.", field.Name, "); err != nil {")
 			p(b, "return err")
 			p(b, "}")
@@ -527,8 +744,9 @@ func readMessages(doc *etree.Document, p *Protocol) error {
 			}
 
 			field := Field{
-				Name: titleCaserNoLower.String(field.Tag),
-				Type: dmlType,
+				Name:    titleCaserNoLower.String(field.Tag),
+				Type:    dmlType,
+				DMLName: field.Tag,
 			}
 
 			msg.Fields = append(msg.Fields, field)
@@ -537,10 +755,18 @@ func readMessages(doc *etree.Document, p *Protocol) error {
 		p.Messages = append(p.Messages, msg)
 	}
 
+	orderMessages(p)
+
+	return nil
+}
+
+// orderMessages removes duplicate messages, assigns orders to messages without one and sorts
+// messages by order.
+func orderMessages(p *Protocol) {
 	p.Messages = dedupeMessagesByTag(p.Messages)
 
 	// Sort messages by tag
-	slices.SortFunc(p.Messages, compareMessageByTag)
+	slices.SortStableFunc(p.Messages, compareMessageByTag)
 
 	for i, msg := range p.Messages {
 		// If no explicit MsgOrder, use the implicit order from sorted slice
@@ -551,9 +777,9 @@ func readMessages(doc *etree.Document, p *Protocol) error {
 	}
 
 	// Sort messages by order
-	slices.SortFunc(p.Messages, compareMessageByOrder)
+	slices.SortStableFunc(p.Messages, compareMessageByOrder)
 
-	return nil
+	resolveIdents(p.Messages)
 }
 
 func readMessageOrder(record *etree.Element, msg *Message) error {
@@ -593,41 +819,48 @@ func readMessageHandler(record *etree.Element, msg *Message) error {
 	if field == nil {
 		return ErrInvalidRecord
 	}
-	msg.Type = strings.ReplaceAll(field.Text()[4:], "_", "")
+	msg.Type = messageType(field.Text())
 	msg.Meta.MsgHandler = field.Text()
 	return nil
 }
 
+// messageType returns the name of the type generated for a message with the given handler
+func messageType(handler string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(handler, "MSG_"), "_", "")
+}
+
 func readProtocolInfo(doc *etree.Document, p *Protocol) error {
 	record := doc.FindElement("//_ProtocolInfo/RECORD")
 	if record == nil {
 		return ErrMissingProtocolInfo
 	}
 
-	search := map[string]string{
-		"ServiceID":           "",
-		"ProtocolType":        "",
-		"ProtocolVersion":     "",
-		"ProtocolDescription": "",
+	search := []struct {
+		name string
+		dst  *string
+	}{
+		{"ServiceID", &p.Meta.ServiceID},
+		{"ProtocolType", &p.Meta.Type},
+		{"ProtocolVersion", &p.Meta.Version},
+		{"ProtocolDescription", &p.Meta.Description},
 	}
-	for k := range search {
-		val := record.FindElement(k)
+	for _, s := range search {
+		val := record.FindElement(s.name)
 		if val == nil {
 			return ErrMissingProtocolInfo
 		}
-		search[k] = val.Text()
+		*s.dst = val.Text()
 	}
 
-	p.Meta.ServiceID = search["ServiceID"]
-	p.Meta.Type = search["ProtocolType"]
-	p.Meta.Version = search["ProtocolVersion"]
-	p.Meta.Description = search["ProtocolDescription"]
+	p.Service = serviceName(p.Meta.Type)
+
+	return nil
+}
 
-	service := titleCaser.String(p.Meta.Type)
+func serviceName(protocolType string) string {
+	service := titleCaser.String(protocolType)
 	service = strings.TrimSuffix(service, "_messages")
 	service = strings.TrimSuffix(service, "messages")
 
-	p.Service = service
-
-	return nil
+	return service
 }
@@ -3,6 +3,7 @@ package codegen
 import (
 	"bytes"
 	"encoding/binary"
+	"unicode/utf16"
 	"unsafe"
 )
 
@@ -22,3 +23,23 @@ func ReadString(buf *bytes.Reader) (string, error) {
 	}
 	return *(*string)(unsafe.Pointer(&data)), nil
 }
+
+// WriteWString writes v as a WSTR, UTF-16LE prefixed with its length in code units
+func WriteWString(b *bytes.Buffer, v string) {
+	units := utf16.Encode([]rune(v))
+	binary.Write(b, binary.LittleEndian, uint16(len(units)))
+	binary.Write(b, binary.LittleEndian, units)
+}
+
+// ReadWString reads a WSTR written by WriteWString
+func ReadWString(buf *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	units := make([]uint16, length)
+	if err := binary.Read(buf, binary.LittleEndian, units); err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(units)), nil
+}
@@ -0,0 +1,25 @@
+package codegen
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWString(t *testing.T) {
+	var b bytes.Buffer
+	WriteWString(&b, "hé😀")
+
+	// 4 code units, the emoji is a surrogate pair
+	expected := []byte{0x04, 0x00, 'h', 0x00, 0xE9, 0x00, 0x3D, 0xD8, 0x00, 0xDE}
+	assert.Equal(t, expected, b.Bytes())
+
+	s, err := ReadWString(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "hé😀", s)
+
+	_, err = ReadWString(bytes.NewReader(expected[:5]))
+	assert.Error(t, err)
+}
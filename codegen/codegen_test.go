@@ -0,0 +1,116 @@
+package codegen
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		opts   []GenerateOption
+	}{
+		{golden: "testdata/test.go.golden"},
+		{golden: "testdata/test_json.go.golden", opts: []GenerateOption{WithJSONTags()}},
+	}
+
+	for _, tt := range tests {
+		pr, err := ReadProtocol("testdata/test.xml")
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+		require.NoError(t, Generate(&b, "test", pr, tt.opts...))
+
+		if *update {
+			require.NoError(t, os.WriteFile(tt.golden, b.Bytes(), 0o644))
+		}
+
+		expected, err := os.ReadFile(tt.golden)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), b.String(), tt.golden)
+	}
+}
+
+func TestReadProtocolFrom(t *testing.T) {
+	expected, err := ReadProtocol("testdata/test.xml")
+	require.NoError(t, err)
+
+	file, err := os.Open("testdata/test.xml")
+	require.NoError(t, err)
+	defer file.Close()
+
+	pr, err := ReadProtocolFrom(file)
+	require.NoError(t, err)
+	assert.Equal(t, expected, pr)
+}
+
+func TestGenerateDir(t *testing.T) {
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+
+	def, err := os.ReadFile("testdata/test.xml")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "a.xml"), def, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "b.xml"), []byte("<Broken>"), 0o644))
+	// Same service as a.xml
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "c.xml"), def, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "ignored.txt"), []byte("not a definition"), 0o644))
+
+	err = GenerateDir(inDir, outDir, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "b.xml")
+	assert.Contains(t, err.Error(), "c.xml")
+	assert.NotContains(t, err.Error(), "a.xml:")
+
+	expected, err := os.ReadFile("testdata/test.go.golden")
+	require.NoError(t, err)
+
+	generated, err := os.ReadFile(filepath.Join(outDir, "test.go"))
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(generated))
+
+	entries, err := os.ReadDir(outDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	generate := func() []byte {
+		pr, err := ReadProtocol("testdata/test.xml")
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+		require.NoError(t, Generate(&b, "test", pr))
+
+		return b.Bytes()
+	}
+
+	first := generate()
+	for range 10 {
+		assert.Equal(t, first, generate())
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	tests := map[string]string{
+		"GAME_MESSAGES":           "Game",
+		"WIZARD_HOUSING_MESSAGES": "Wizard_housing",
+		"MOVEBEHAVIORMESSAGES":    "Movebehavior",
+		"extendedbase":            "Extendedbase",
+		"TEST":                    "Test",
+		"":                        "",
+	}
+
+	for protocolType, expected := range tests {
+		assert.Equal(t, expected, serviceName(protocolType), "protocol type %q", protocolType)
+	}
+}
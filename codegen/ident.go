@@ -0,0 +1,88 @@
+package codegen
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// reservedTypeNames are declared by the generated code, messages with these names are
+	// renamed
+	reservedTypeNames = []string{"Client", "Handlers", "NewClient", "Register", "RegisterService", "Service", "ServiceID"}
+	// reservedFieldNames are methods of the generated messages, fields with these names are
+	// renamed
+	reservedFieldNames = []string{"Marshal", "Order", "Service", "String", "Unmarshal"}
+)
+
+// exportedIdent turns s into a valid exported Go identifier. Characters that can't appear in an
+// identifier are dropped and the first letter is upper cased. If s doesn't start with a letter
+// that has an upper case, it's prefixed with X.
+func exportedIdent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+
+	first, size := firstRune(s)
+	if !unicode.IsUpper(unicode.ToUpper(first)) {
+		return "X" + s
+	}
+
+	return string(unicode.ToUpper(first)) + s[size:]
+}
+
+func firstRune(s string) (rune, int) {
+	for _, r := range s {
+		return r, len(string(r))
+	}
+
+	return 0, 0
+}
+
+// identSet hands out identifiers that don't collide with each other or with reserved names
+type identSet struct {
+	taken map[string]bool
+}
+
+func newIdentSet(reserved []string) identSet {
+	set := identSet{taken: make(map[string]bool)}
+	for _, name := range reserved {
+		set.taken[name] = true
+	}
+
+	return set
+}
+
+// claim returns name if it's free, otherwise name suffixed with the first number from 2 that
+// makes it free.
+func (s identSet) claim(name string) string {
+	unique := name
+	for i := 2; s.taken[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+
+	s.taken[unique] = true
+	return unique
+}
+
+// resolveIdents makes message and field names valid and unique, in message and field order so
+// the result is deterministic.
+func resolveIdents(msgs []Message) {
+	types := newIdentSet(reservedTypeNames)
+
+	for i := range msgs {
+		msgs[i].Type = types.claim(exportedIdent(msgs[i].Type))
+
+		fields := newIdentSet(reservedFieldNames)
+		for j := range msgs[i].Fields {
+			field := &msgs[i].Fields[j]
+			if field.DMLName == "" {
+				field.DMLName = field.Name
+			}
+			field.Name = fields.claim(exportedIdent(field.Name))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package codegen
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportedIdent(t *testing.T) {
+	tests := map[string]string{
+		"Value":   "Value",
+		"type":    "Type",
+		"range":   "Range",
+		"2ndItem": "X2ndItem",
+		"_hidden": "X_hidden",
+		"a-b.c":   "Abc",
+		"été":     "Été",
+		"":        "X",
+		"日本":      "X日本",
+	}
+
+	for in, expected := range tests {
+		assert.Equal(t, expected, exportedIdent(in), "input %q", in)
+	}
+}
+
+const adversarialProtocol = `<?xml version="1.0" ?>
+<AdversarialMessages>
+  <_ProtocolInfo>
+    <RECORD>
+      <ServiceID TYPE="UBYT">7</ServiceID>
+      <ProtocolType TYPE="STR">ADVERSARIAL</ProtocolType>
+      <ProtocolVersion TYPE="INT">1</ProtocolVersion>
+      <ProtocolDescription TYPE="STR">Adversarial names</ProtocolDescription>
+    </RECORD>
+  </_ProtocolInfo>
+  <MSG_A>
+    <RECORD>
+      <_MsgName TYPE="STR" NOXFER="TRUE">MSG_A</_MsgName>
+      <_MsgDescription TYPE="STR" NOXFER="TRUE"></_MsgDescription>
+      <_MsgHandler TYPE="STR" NOXFER="TRUE">MSG_Client</_MsgHandler>
+      <_MsgOrder TYPE="UBYT" NOXFER="TRUE">1</_MsgOrder>
+      <type TYPE="INT"></type>
+      <range TYPE="INT"></range>
+      <_1st TYPE="INT"></_1st>
+      <value TYPE="INT"></value>
+      <Value TYPE="INT"></Value>
+      <Marshal TYPE="STR"></Marshal>
+    </RECORD>
+  </MSG_A>
+  <MSG_B>
+    <RECORD>
+      <_MsgName TYPE="STR" NOXFER="TRUE">MSG_B</_MsgName>
+      <_MsgDescription TYPE="STR" NOXFER="TRUE"></_MsgDescription>
+      <_MsgHandler TYPE="STR" NOXFER="TRUE">MSG_2_Client</_MsgHandler>
+      <_MsgOrder TYPE="UBYT" NOXFER="TRUE">2</_MsgOrder>
+    </RECORD>
+  </MSG_B>
+  <MSG_C>
+    <RECORD>
+      <_MsgName TYPE="STR" NOXFER="TRUE">MSG_C</_MsgName>
+      <_MsgDescription TYPE="STR" NOXFER="TRUE"></_MsgDescription>
+      <_MsgHandler TYPE="STR" NOXFER="TRUE">MSG_Client</_MsgHandler>
+      <_MsgOrder TYPE="UBYT" NOXFER="TRUE">3</_MsgOrder>
+    </RECORD>
+  </MSG_C>
+</AdversarialMessages>`
+
+func TestResolveIdents(t *testing.T) {
+	pr, err := UnmarshalProtocol([]byte(adversarialProtocol))
+	require.NoError(t, err)
+
+	var types []string
+	for _, msg := range pr.Messages {
+		types = append(types, msg.Type)
+	}
+	assert.Equal(t, []string{"Client2", "X2Client", "Client3"}, types)
+
+	var fields []string
+	for _, field := range pr.Messages[0].Fields {
+		fields = append(fields, field.Name)
+	}
+	assert.Equal(t, []string{"Type", "Range", "X_1St", "Value", "Value2", "Marshal2"}, fields)
+
+	var b bytes.Buffer
+	assert.NoError(t, Generate(&b, "adversarial", pr))
+}
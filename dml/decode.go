@@ -2,9 +2,14 @@ package dml
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"sync"
+	"unicode/utf16"
 )
 
 const (
@@ -12,8 +17,9 @@ const (
 	TypeRecord         = 2
 )
 
+// Field types are numbered from 1
 const (
-	GID = iota
+	GID = iota + 1
 	INT
 	UINT
 	FLT
@@ -23,15 +29,95 @@ const (
 	DBL
 	STR
 	WSTR
+	// SHRT hasn't been seen in game files, its value is assumed to follow WSTR
+	SHRT
 )
 
 type Record map[string]any
 
+// FieldTransform is applied to each decoded field value before it is stored in the record. The
+// returned value is stored in place of the decoded one.
+type FieldTransform func(field RecordField, v any) any
+
+// ErrLimitExceeded is returned when a table exceeds one of the limits set on decode.
+var ErrLimitExceeded = errors.New("dml: limit exceeded")
+
+const (
+	defaultMaxRecords      = 1 << 20
+	defaultMaxStringLength = 1 << 14
+)
+
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	transform       FieldTransform
+	maxRecords      uint32
+	maxStringLength int
+	workers         int
+}
+
+func defaultDecodeOptions() decodeOptions {
+	return decodeOptions{
+		maxRecords:      defaultMaxRecords,
+		maxStringLength: defaultMaxStringLength,
+	}
+}
+
+// WithFieldTransform sets a transform to apply to every field value as records are decoded.
+func WithFieldTransform(transform FieldTransform) DecodeOption {
+	return func(o *decodeOptions) {
+		o.transform = transform
+	}
+}
+
+// WithMaxRecords sets the maximum number of records a table may declare. The default is 1048576.
+func WithMaxRecords(n uint32) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxRecords = n
+	}
+}
+
+// WithMaxStringLength sets the maximum length of STR and WSTR values, in bytes and UTF-16 code
+// units respectively. The default is 16384.
+func WithMaxStringLength(n int) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxStringLength = n
+	}
+}
+
+// WithParallelDecode decodes the records of each table on up to workers goroutines, keeping them
+// in order. Every record is prefixed with its size, so the records of a table are read one after
+// another and only decoding their fields is spread out, see BenchmarkDecodeTable. It only pays
+// off for tables with many records on a machine with cores to spare, and holds a table's encoded
+// records in memory while they're decoded. A FieldTransform must be safe to call concurrently
+// when this is set. DecodeRecordSeq yields records as they're read, so it always decodes
+// sequentially.
+func WithParallelDecode(workers int) DecodeOption {
+	return func(o *decodeOptions) {
+		o.workers = workers
+	}
+}
+
 type Table struct {
-	Name    string
+	Name string
+	// Fields are the fields of the table's template in the order they appear on the wire
+	Fields  []RecordField
 	Records []Record
 }
 
+// Template returns the template describing the table's records, as it's encoded before them.
+// Size is the size of the template section including its header.
+func (t Table) Template() RecordTemplate {
+	var b bytes.Buffer
+	encodeTemplate(&b, t.Fields, t.Name)
+
+	return RecordTemplate{
+		Size:   uint16(recordHeaderSize + b.Len()),
+		Fields: t.Fields,
+		Table:  t.Name,
+	}
+}
+
 type RecordTemplate struct {
 	Size   uint16
 	Fields []RecordField
@@ -84,82 +170,231 @@ func (t *TargetTable) decode(reader *bufio.Reader) error {
 	return nil
 }
 
-func DecodeTable(r io.Reader) (*[]Table, error) {
-	bufReader := bufio.NewReader(r)
-
+// DecodeTable decodes every table in r. Field values aren't checked beyond their types. In
+// particular HeaderCRC fields, as in file manifest tables, aren't checksums of the DML data: they
+// cover the header of the file named by the record, which isn't available here, and the CRC
+// variant KingsIsle use for them is unconfirmed, so they're left unvalidated.
+func DecodeTable(r io.Reader, opts ...DecodeOption) ([]Table, error) {
 	var tables []Table
 
-	for {
-		var length uint32
-		if err := binary.Read(bufReader, binary.LittleEndian, &length); err == io.EOF {
-			break
-		}
-
-		table, err := readTable(bufReader, length)
-		if err == io.EOF {
-			return nil, fmt.Errorf("expected table with length %v", length)
-		}
+	for table, err := range DecodeTableSeq(r, opts...) {
 		if err != nil {
 			return nil, err
 		}
 
-		tables = append(tables, *table)
+		tables = append(tables, table)
 	}
 
-	return &tables, nil
+	return tables, nil
 }
 
-func readTableHeader(r *bufio.Reader) (uint8, error) {
-	_, err := r.Discard(1)
-	if err != nil {
-		return 0, err
+// DecodeTableBytes is like DecodeTable but decodes from a byte slice.
+func DecodeTableBytes(data []byte, opts ...DecodeOption) ([]Table, error) {
+	return DecodeTable(bytes.NewReader(data), opts...)
+}
+
+// DecodeTableSeq yields tables one at a time as they're decoded. Only the records of the table
+// being decoded are held in memory, use DecodeRecordSeq to avoid holding a whole table.
+func DecodeTableSeq(r io.Reader, opts ...DecodeOption) iter.Seq2[Table, error] {
+	return func(yield func(Table, error) bool) {
+		d := newDecoder(r, opts)
+
+		for {
+			rc, length, err := d.readTableStart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(Table{}, err)
+				return
+			}
+
+			records, err := d.readRecords(rc, length)
+			if err != nil {
+				yield(Table{}, err)
+				return
+			}
+
+			table := Table{
+				Name:    rc.Table,
+				Fields:  rc.Fields,
+				Records: records,
+			}
+
+			if !yield(table, nil) {
+				return
+			}
+		}
 	}
-	srv, err := r.ReadByte()
-	if err != nil {
-		return 0, err
+}
+
+// DecodeRecordSeq yields records one at a time as they're decoded so they can be processed and
+// discarded. Records from every table are yielded in order.
+func DecodeRecordSeq(r io.Reader, opts ...DecodeOption) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		d := newDecoder(r, opts)
+
+		for {
+			rc, length, err := d.readTableStart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for i := uint32(0); i < length; i++ {
+				record, err := d.readNextRecord(rc)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+
+				if !yield(record, nil) {
+					return
+				}
+			}
+		}
 	}
+}
 
-	return srv, nil
+type decoder struct {
+	r    *bufio.Reader
+	opts decodeOptions
 }
 
-func readTable(r *bufio.Reader, length uint32) (*Table, error) {
-	srv, err := readTableHeader(r)
+func newDecoder(r io.Reader, opts []DecodeOption) *decoder {
+	d := &decoder{
+		r:    bufio.NewReader(r),
+		opts: defaultDecodeOptions(),
+	}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+
+	return d
+}
+
+// readTableStart reads the record count and template of the next table. It returns io.EOF if
+// there are no more tables.
+func (d *decoder) readTableStart() (*RecordTemplate, uint32, error) {
+	var length uint32
+	if err := binary.Read(d.r, binary.LittleEndian, &length); err != nil {
+		return nil, 0, err
+	}
+	if length > d.opts.maxRecords {
+		return nil, 0, fmt.Errorf("%w: table declares %v records, maximum is %v", ErrLimitExceeded, length, d.opts.maxRecords)
+	}
+
+	srv, err := readTableHeader(d.r)
+	if err == io.EOF {
+		return nil, 0, fmt.Errorf("expected table with length %v", length)
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if srv != TypeRecordTemplate {
-		return nil, fmt.Errorf("failed to read record template")
+		return nil, 0, fmt.Errorf("failed to read record template")
 	}
 
 	// RecordTemplate always precedes the Records
-	rc, err := readRecordTemplate(r)
+	rc, err := readRecordTemplate(d.r)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	var records []Record
+	return rc, length, nil
+}
+
+// readRecords reads the length records of a table, decoding them in parallel if enabled.
+func (d *decoder) readRecords(rc *RecordTemplate, length uint32) ([]Record, error) {
+	if d.opts.workers <= 1 || length < 2 {
+		var records []Record
+		for i := uint32(0); i < length; i++ {
+			record, err := d.readNextRecord(rc)
+			if err != nil {
+				return nil, err
+			}
+
+			records = append(records, record)
+		}
+
+		return records, nil
+	}
 
-	for i := uint32(0); i < length; i++ {
-		srv, err := readTableHeader(r)
+	bodies := make([][]byte, length)
+	for i := range bodies {
+		body, err := d.readNextRecordBody()
 		if err != nil {
 			return nil, err
 		}
-		if srv != TypeRecord {
-			return nil, fmt.Errorf("unknown value type %v", srv)
-		}
 
-		record, err := readRecord(r, rc)
+		bodies[i] = body
+	}
+
+	var (
+		records = make([]Record, length)
+		errs    = make([]error, length)
+		workers = min(d.opts.workers, len(bodies))
+		wg      sync.WaitGroup
+	)
+
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := w; i < len(bodies); i += workers {
+				records[i], errs[i] = decodeRecord(bodies[i], rc, &d.opts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Report the error of the first bad record, as decoding sequentially would
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		records = append(records, record)
+	return records, nil
+}
+
+func (d *decoder) readNextRecord(rc *RecordTemplate) (Record, error) {
+	body, err := d.readNextRecordBody()
+	if err != nil {
+		return nil, err
 	}
 
-	return &Table{
-		Name:    rc.Table,
-		Records: records,
-	}, nil
+	return decodeRecord(body, rc, &d.opts)
+}
+
+// readNextRecordBody reads the next record section and returns the record's encoded fields.
+func (d *decoder) readNextRecordBody() ([]byte, error) {
+	srv, err := readTableHeader(d.r)
+	if err != nil {
+		return nil, err
+	}
+	if srv != TypeRecord {
+		return nil, fmt.Errorf("unknown value type %v", srv)
+	}
+
+	return readRecordBody(d.r)
+}
+
+func readTableHeader(r *bufio.Reader) (uint8, error) {
+	_, err := r.Discard(1)
+	if err != nil {
+		return 0, err
+	}
+	srv, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	return srv, nil
 }
 
 func readRecordTemplate(r *bufio.Reader) (*RecordTemplate, error) {
@@ -198,11 +433,32 @@ func readRecordTemplate(r *bufio.Reader) (*RecordTemplate, error) {
 	}, nil
 }
 
-func readRecord(r *bufio.Reader, rc *RecordTemplate) (Record, error) {
+// recordHeaderSize is the size of the header and size prefix that are counted in a record's size
+const recordHeaderSize = 4
+
+func readRecordBody(br *bufio.Reader) ([]byte, error) {
 	var size uint16
-	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
 		return nil, err
 	}
+	if size < recordHeaderSize {
+		return nil, fmt.Errorf("invalid record size %v", size)
+	}
+
+	// Records written by newer clients may have trailing fields we don't know about, so read the
+	// whole record and ignore anything left over after the known fields
+	body := make([]byte, size-recordHeaderSize)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// decodeRecord decodes the fields of a record from its body. It only reads body, so records can
+// be decoded concurrently.
+func decodeRecord(body []byte, rc *RecordTemplate, opts *decodeOptions) (Record, error) {
+	r := bytes.NewReader(body)
 
 	record := make(Record)
 
@@ -215,7 +471,7 @@ func readRecord(r *bufio.Reader, rc *RecordTemplate) (Record, error) {
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case INT:
-			var v uint32
+			var v int32
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case UINT:
@@ -223,45 +479,81 @@ func readRecord(r *bufio.Reader, rc *RecordTemplate) (Record, error) {
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case FLT:
-			var v uint32
+			var v float32
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case BYT:
-			var v uint8
+			var v int8
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case UBYT:
 			var v uint8
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
+		case SHRT:
+			var v int16
+			err = binary.Read(r, binary.LittleEndian, &v)
+			record[field.Name] = v
 		case USHRT:
 			var v uint16
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case DBL:
-			var v uint64
+			var v float64
 			err = binary.Read(r, binary.LittleEndian, &v)
 			record[field.Name] = v
 		case STR:
-			fallthrough
-		case WSTR:
 			var len uint16
 			if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
 				return nil, err
 			}
+			if err := checkStringLength(field, int(len), 1, r, opts); err != nil {
+				return nil, err
+			}
 
 			v := make([]byte, len)
 			_, err = io.ReadFull(r, v)
 
 			record[field.Name] = string(v)
+		case WSTR:
+			// Length is in UTF-16 code units
+			var len uint16
+			if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
+				return nil, err
+			}
+			if err := checkStringLength(field, int(len), 2, r, opts); err != nil {
+				return nil, err
+			}
+
+			v := make([]uint16, len)
+			err = binary.Read(r, binary.LittleEndian, v)
+
+			record[field.Name] = string(utf16.Decode(v))
 		default:
-			panic("unknown field type")
+			return nil, fmt.Errorf("dml: unknown field type %d for field %q", field.Type, field.Name)
 		}
 
 		if err != nil {
 			return nil, err
 		}
+
+		if opts.transform != nil {
+			record[field.Name] = opts.transform(field, record[field.Name])
+		}
 	}
 
 	return record, nil
 }
+
+// checkStringLength validates a string length read off the wire before anything is allocated
+// for it. unitSize is the size in bytes of each unit counted by length.
+func checkStringLength(field RecordField, length, unitSize int, r *bytes.Reader, opts *decodeOptions) error {
+	if length > opts.maxStringLength {
+		return fmt.Errorf("%w: field %q has length %v, maximum is %v", ErrLimitExceeded, field.Name, length, opts.maxStringLength)
+	}
+	if length*unitSize > r.Len() {
+		return fmt.Errorf("field %q has length %v but only %v bytes remain in record", field.Name, length, r.Len())
+	}
+
+	return nil
+}
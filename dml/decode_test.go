@@ -1,6 +1,10 @@
 package dml
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 
@@ -15,7 +19,7 @@ func TestDecodeTable1(t *testing.T) {
 	tables, err := DecodeTable(file)
 	require.NoError(t, err)
 
-	first := (*tables)[0]
+	first := tables[0]
 
 	assert.Equal(t, "_TableList", first.Name)
 	assert.Equal(t, 1, len(first.Records))
@@ -29,10 +33,283 @@ func TestDecodeTable2(t *testing.T) {
 	tables, err := DecodeTable(file)
 	require.NoError(t, err)
 
-	first := (*tables)[0]
+	first := tables[0]
 
 	assert.Equal(t, "_Shared-WorldData", first.Name)
 	assert.Equal(t, 1, len(first.Records))
 	assert.Equal(t, uint32(2647210788), first.Records[0]["HeaderCRC"])
 	assert.Equal(t, "Data/GameData/_Shared-WorldData.wad", first.Records[0]["SrcFileName"])
 }
+
+func TestDecodeTableTemplate(t *testing.T) {
+	data, err := os.ReadFile("testdata/dml2.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTableBytes(data)
+	require.NoError(t, err)
+
+	first := tables[0]
+	template := first.Template()
+	assert.Equal(t, first.Name, template.Table)
+	assert.Equal(t, first.Fields, template.Fields)
+
+	// The template section follows the record count, its size is after the section header
+	assert.Equal(t, binary.LittleEndian.Uint16(data[6:8]), template.Size)
+}
+
+func TestDecodeTableFieldTransform(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+
+	double := func(field RecordField, v any) any {
+		if field.Name == "FileType" {
+			return v.(uint32) * 2
+		}
+		return v
+	}
+
+	tables, err := DecodeTable(file, WithFieldTransform(double))
+	require.NoError(t, err)
+
+	first := tables[0]
+
+	assert.Equal(t, uint32(6), first.Records[0]["FileType"])
+	assert.Equal(t, uint32(2647210788), first.Records[0]["HeaderCRC"])
+}
+
+func TestDecodeTableTrailingBytes(t *testing.T) {
+	file, err := os.Open("testdata/dml3.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTable(file)
+	require.NoError(t, err)
+
+	first := tables[0]
+
+	assert.Equal(t, "Trailing", first.Name)
+	assert.Equal(t, 2, len(first.Records))
+	assert.Equal(t, "First", first.Records[0]["Name"])
+	assert.Equal(t, uint32(1), first.Records[0]["Value"])
+	assert.Equal(t, "Second", first.Records[1]["Name"])
+	assert.Equal(t, uint32(2), first.Records[1]["Value"])
+}
+
+func TestDecodeTableWideString(t *testing.T) {
+	file, err := os.Open("testdata/dml4.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTable(file)
+	require.NoError(t, err)
+
+	first := tables[0]
+
+	assert.Equal(t, "WideStrings", first.Name)
+	assert.Equal(t, "Zauberer – Ünïcödé 🧙", first.Records[0]["Display"])
+	assert.Equal(t, "ascii", first.Records[0]["Key"])
+}
+
+func TestDecodeTableFloats(t *testing.T) {
+	file, err := os.Open("testdata/dml5.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTable(file)
+	require.NoError(t, err)
+
+	first := tables[0]
+
+	assert.Equal(t, "Coordinates", first.Name)
+	assert.Equal(t, float32(123.5), first.Records[0]["LocationX"])
+	assert.Equal(t, float64(-45.25), first.Records[0]["Rotation"])
+}
+
+func TestDecodeTableSigned(t *testing.T) {
+	file, err := os.Open("testdata/dml6.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTable(file)
+	require.NoError(t, err)
+
+	first := tables[0]
+
+	assert.Equal(t, "Signed", first.Name)
+	assert.Equal(t, int32(-50), first.Records[0]["Health"])
+	assert.Equal(t, int8(-3), first.Records[0]["Offset"])
+	assert.Equal(t, int16(-1000), first.Records[0]["Delta"])
+}
+
+func TestDecodeTableSeq(t *testing.T) {
+	tables := []Table{
+		{Name: "First", Records: []Record{{"Value": uint32(1)}}},
+		{Name: "Second", Records: []Record{{"Value": uint32(2)}}},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, tables))
+
+	var names []string
+	for table, err := range DecodeTableSeq(bytes.NewReader(b.Bytes())) {
+		require.NoError(t, err)
+		names = append(names, table.Name)
+		break
+	}
+	assert.Equal(t, []string{"First"}, names)
+}
+
+func TestDecodeRecordSeq(t *testing.T) {
+	table := Table{Name: "Large"}
+	for i := range 1000 {
+		table.Records = append(table.Records, Record{"Value": uint32(i)})
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, []Table{table}))
+
+	var count uint32
+	for record, err := range DecodeRecordSeq(&b) {
+		require.NoError(t, err)
+		assert.Equal(t, count, record["Value"])
+		count++
+	}
+	assert.Equal(t, uint32(1000), count)
+}
+
+func TestDecodeRecordSeqTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/dml2.bin")
+	require.NoError(t, err)
+
+	var lastErr error
+	for _, err := range DecodeRecordSeq(bytes.NewReader(data[:len(data)-4])) {
+		lastErr = err
+	}
+	assert.Error(t, lastErr)
+}
+
+func TestDecodeTableUnknownFieldType(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, []Table{
+		{Name: "Unknown", Records: []Record{{"Value": uint32(1)}}},
+	}))
+
+	data := b.Bytes()
+	// Type byte of the Value field follows the record count, template header and field name
+	typeOffset := 4 + 4 + 2 + len("Value")
+	require.Equal(t, byte(UINT), data[typeOffset])
+	data[typeOffset] = 0xFF
+
+	_, err := DecodeTable(bytes.NewReader(data))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field type 255 for field "Value"`)
+}
+
+func TestDecodeTableMaxRecords(t *testing.T) {
+	data := []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+	_, err := DecodeTable(bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+
+	file, err := os.Open("testdata/dml3.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = DecodeTable(file, WithMaxRecords(1))
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestDecodeTableMaxStringLength(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = DecodeTable(file, WithMaxStringLength(8))
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestDecodeTableStringPastRecord(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, []Table{
+		{Name: "Short", Records: []Record{{"Name": "abc"}}},
+	}))
+
+	data := b.Bytes()
+	// Length prefix of the only string value is 5 bytes from the end
+	data[len(data)-5] = 0xFF
+
+	_, err := DecodeTable(bytes.NewReader(data))
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestDecodeTableBytes(t *testing.T) {
+	data, err := os.ReadFile("testdata/dml2.bin")
+	require.NoError(t, err)
+
+	tables, err := DecodeTableBytes(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "_Shared-WorldData", tables[0].Name)
+	assert.Equal(t, uint32(2647210788), tables[0].Records[0]["HeaderCRC"])
+}
+
+// encodeLargeTable encodes a table of n records with a mix of field types
+func encodeLargeTable(t testing.TB, n int) []byte {
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			"ID":     uint64(i),
+			"Name":   fmt.Sprintf("Object%v", i),
+			"Health": int32(i * 3),
+			"Speed":  float32(i) / 2,
+			"Scale":  float64(i) / 4,
+			"Flags":  uint8(i),
+		}
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, []Table{{Name: "Large", Records: records}}))
+
+	return b.Bytes()
+}
+
+func TestDecodeTableParallel(t *testing.T) {
+	data := encodeLargeTable(t, 1000)
+
+	sequential, err := DecodeTableBytes(data)
+	require.NoError(t, err)
+
+	for _, workers := range []int{2, 8, 2000} {
+		parallel, err := DecodeTableBytes(data, WithParallelDecode(workers))
+		require.NoError(t, err)
+		assert.Equal(t, sequential, parallel, "workers=%v", workers)
+	}
+}
+
+func TestDecodeTableParallelError(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, []Table{
+		{Name: "Short", Records: []Record{{"Name": "abc"}, {"Name": "def"}}},
+	}))
+
+	data := b.Bytes()
+	// Length prefix of the last string value is 5 bytes from the end
+	data[len(data)-5] = 0xFF
+
+	_, err := DecodeTableBytes(data, WithParallelDecode(4))
+	assert.Error(t, err)
+}
+
+func BenchmarkDecodeTable(b *testing.B) {
+	data := encodeLargeTable(b, 100_000)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("Workers%v", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+
+			for range b.N {
+				if _, err := DecodeTableBytes(data, WithParallelDecode(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package dml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"unicode/utf16"
+)
+
+const (
+	// headerPrefix is the first byte of every template and record header, it's ignored on decode
+	headerPrefix = 0x02
+	// fieldSuffix follows the type of every field in a template, it's ignored on decode
+	fieldSuffix = 0x28
+	// targetTableType is the type given to the _TargetTable field in game files
+	targetTableType = STR
+)
+
+func (r *RecordField) encode(b *bytes.Buffer) {
+	binary.Write(b, binary.LittleEndian, uint16(len(r.Name)))
+	b.WriteString(r.Name)
+	b.WriteByte(r.Type)
+	b.WriteByte(fieldSuffix)
+}
+
+func (t *TargetTable) encode(b *bytes.Buffer) {
+	binary.Write(b, binary.LittleEndian, uint16(len(t.Name)))
+	b.WriteString(t.Name)
+}
+
+// EncodeTable writes tables in the format read by DecodeTable. Fields are written in the order
+// given by Table.Fields. If a table has no fields set, they're derived from the Go types of the
+// values in its first record and written in name order. Every record in a table must have the
+// same fields.
+func EncodeTable(w io.Writer, tables []Table) error {
+	var b bytes.Buffer
+
+	for _, table := range tables {
+		if err := writeTable(&b, table); err != nil {
+			return fmt.Errorf("dml: error encoding table %v: %w", table.Name, err)
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeTable(b *bytes.Buffer, table Table) error {
+	fields := table.Fields
+	if fields == nil {
+		var err error
+		if fields, err = deriveFields(table); err != nil {
+			return err
+		}
+	}
+
+	binary.Write(b, binary.LittleEndian, uint32(len(table.Records)))
+
+	var template bytes.Buffer
+	encodeTemplate(&template, fields, table.Name)
+
+	if err := writeSection(b, TypeRecordTemplate, template.Bytes()); err != nil {
+		return err
+	}
+
+	for _, record := range table.Records {
+		var body bytes.Buffer
+		if err := writeRecord(&body, fields, record); err != nil {
+			return err
+		}
+
+		if err := writeSection(b, TypeRecord, body.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeTemplate writes the body of a template section for a table
+func encodeTemplate(b *bytes.Buffer, fields []RecordField, table string) {
+	for _, field := range fields {
+		field.encode(b)
+	}
+	targetField := RecordField{Name: "_TargetTable", Type: targetTableType}
+	targetField.encode(b)
+	target := TargetTable{Name: table}
+	target.encode(b)
+}
+
+func writeSection(b *bytes.Buffer, srv uint8, body []byte) error {
+	size := recordHeaderSize + len(body)
+	if size > math.MaxUint16 {
+		return fmt.Errorf("section size %v exceeds %v", size, math.MaxUint16)
+	}
+
+	b.WriteByte(headerPrefix)
+	b.WriteByte(srv)
+	binary.Write(b, binary.LittleEndian, uint16(size))
+	b.Write(body)
+
+	return nil
+}
+
+func deriveFields(table Table) ([]RecordField, error) {
+	if len(table.Records) == 0 {
+		return nil, nil
+	}
+
+	first := table.Records[0]
+
+	var fields []RecordField
+	for _, name := range slices.Sorted(maps.Keys(first)) {
+		fieldType, ok := fieldTypeOf(first[name])
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %T for field %q", first[name], name)
+		}
+
+		fields = append(fields, RecordField{Name: name, Type: fieldType})
+	}
+
+	return fields, nil
+}
+
+func fieldTypeOf(v any) (uint8, bool) {
+	switch v.(type) {
+	case uint64:
+		return GID, true
+	case int32:
+		return INT, true
+	case uint32:
+		return UINT, true
+	case float32:
+		return FLT, true
+	case int8:
+		return BYT, true
+	case uint8:
+		return UBYT, true
+	case int16:
+		return SHRT, true
+	case uint16:
+		return USHRT, true
+	case float64:
+		return DBL, true
+	case string:
+		return STR, true
+	default:
+		return 0, false
+	}
+}
+
+func writeRecord(b *bytes.Buffer, fields []RecordField, record Record) error {
+	if len(record) != len(fields) {
+		return fmt.Errorf("record has %v fields but template has %v", len(record), len(fields))
+	}
+
+	for _, field := range fields {
+		v, ok := record[field.Name]
+		if !ok {
+			return fmt.Errorf("record is missing field %q", field.Name)
+		}
+
+		if err := writeValue(b, field, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeValue(b *bytes.Buffer, field RecordField, v any) error {
+	if fieldType, ok := fieldTypeOf(v); !ok || !compatibleType(field.Type, fieldType) {
+		return fmt.Errorf("value of type %T does not match type %v of field %q", v, field.Type, field.Name)
+	}
+
+	switch field.Type {
+	case STR:
+		s := v.(string)
+		if len(s) > math.MaxUint16 {
+			return fmt.Errorf("string length %v of field %q exceeds %v", len(s), field.Name, math.MaxUint16)
+		}
+
+		binary.Write(b, binary.LittleEndian, uint16(len(s)))
+		b.WriteString(s)
+	case WSTR:
+		s := utf16.Encode([]rune(v.(string)))
+		if len(s) > math.MaxUint16 {
+			return fmt.Errorf("string length %v of field %q exceeds %v", len(s), field.Name, math.MaxUint16)
+		}
+
+		binary.Write(b, binary.LittleEndian, uint16(len(s)))
+		binary.Write(b, binary.LittleEndian, s)
+	default:
+		binary.Write(b, binary.LittleEndian, v)
+	}
+
+	return nil
+}
+
+// compatibleType reports whether a value of valueType can be written to a field of fieldType
+func compatibleType(fieldType, valueType uint8) bool {
+	if fieldType == WSTR {
+		return valueType == STR
+	}
+
+	return fieldType == valueType
+}
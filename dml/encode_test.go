@@ -0,0 +1,112 @@
+package dml
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeTableRoundTrip(t *testing.T) {
+	tables := []Table{
+		{
+			Name: "Everything",
+			Records: []Record{
+				{
+					"Gid":    uint64(0xDEADBEEFCAFE),
+					"Uint":   uint32(2647210788),
+					"Ubyt":   uint8(255),
+					"Ushrt":  uint16(65535),
+					"Int":    int32(-50),
+					"Byt":    int8(-3),
+					"Shrt":   int16(-1000),
+					"Flt":    float32(123.5),
+					"Dbl":    float64(-45.25),
+					"Str":    "Data/GameData/Root.wad",
+					"Empty":  "",
+					"Second": uint32(1),
+				},
+				{
+					"Gid":    uint64(1),
+					"Uint":   uint32(0),
+					"Ubyt":   uint8(0),
+					"Ushrt":  uint16(1),
+					"Int":    int32(2147483647),
+					"Byt":    int8(127),
+					"Shrt":   int16(0),
+					"Flt":    float32(0),
+					"Dbl":    float64(1e100),
+					"Str":    "",
+					"Empty":  "",
+					"Second": uint32(2),
+				},
+			},
+		},
+		{
+			Name:    "Empty",
+			Records: nil,
+		},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, EncodeTable(&b, tables))
+
+	decoded, err := DecodeTable(&b)
+	require.NoError(t, err)
+
+	// Derived fields are written in name order
+	for i := range tables {
+		tables[i].Fields, err = deriveFields(tables[i])
+		require.NoError(t, err)
+	}
+	assert.Equal(t, tables, decoded)
+}
+
+func TestEncodeTableFixtures(t *testing.T) {
+	for _, fixture := range []string{"testdata/dml1.bin", "testdata/dml2.bin", "testdata/dml3.bin", "testdata/dml4.bin", "testdata/dml5.bin", "testdata/dml6.bin"} {
+		file, err := os.Open(fixture)
+		require.NoError(t, err)
+
+		tables, err := DecodeTable(file)
+		file.Close()
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+		require.NoError(t, EncodeTable(&b, tables))
+
+		decoded, err := DecodeTable(&b)
+		require.NoError(t, err)
+		assert.Equal(t, tables, decoded)
+	}
+}
+
+func TestEncodeTablePreservesFieldOrder(t *testing.T) {
+	// dml3.bin has trailing bytes in its records which aren't kept
+	for _, fixture := range []string{"testdata/dml1.bin", "testdata/dml2.bin", "testdata/dml4.bin", "testdata/dml5.bin", "testdata/dml6.bin"} {
+		data, err := os.ReadFile(fixture)
+		require.NoError(t, err)
+
+		tables, err := DecodeTable(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+		require.NoError(t, EncodeTable(&b, tables))
+		assert.Equal(t, data, b.Bytes(), fixture)
+	}
+}
+
+func TestEncodeTableMismatchedRecords(t *testing.T) {
+	tables := []Table{
+		{
+			Name: "Mismatched",
+			Records: []Record{
+				{"Value": uint32(1)},
+				{"Value": "one"},
+			},
+		},
+	}
+
+	assert.Error(t, EncodeTable(&bytes.Buffer{}, tables))
+}
@@ -0,0 +1,24 @@
+package dml
+
+// FindTable returns the first table with the given name.
+func FindTable(tables []Table, name string) (Table, bool) {
+	for _, table := range tables {
+		if table.Name == name {
+			return table, true
+		}
+	}
+
+	return Table{}, false
+}
+
+// FilterTables returns every table with the given name, in the order they appear in tables.
+func FilterTables(tables []Table, name string) []Table {
+	var filtered []Table
+	for _, table := range tables {
+		if table.Name == name {
+			filtered = append(filtered, table)
+		}
+	}
+
+	return filtered
+}
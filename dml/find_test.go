@@ -0,0 +1,25 @@
+package dml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindTable(t *testing.T) {
+	tables := []Table{
+		{Name: "_TableList"},
+		{Name: "Repeated", Records: []Record{{"Value": uint32(1)}}},
+		{Name: "Repeated", Records: []Record{{"Value": uint32(2)}}},
+	}
+
+	table, ok := FindTable(tables, "Repeated")
+	assert.True(t, ok)
+	assert.Equal(t, tables[1], table)
+
+	_, ok = FindTable(tables, "Missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, tables[1:], FilterTables(tables, "Repeated"))
+	assert.Empty(t, FilterTables(tables, "Missing"))
+}
@@ -0,0 +1,134 @@
+package dml
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// DecodeInto decodes tables from r and appends their records to out. Struct fields are matched to
+// record fields by their `dml:"FieldName"` tag, untagged fields are left alone. Decoded values are
+// converted to the type of the struct field, it is an error for a tagged field to be missing from
+// a record or to have a type that can't hold the decoded value. Integers only convert to integer
+// fields, floats to float fields and strings to string fields.
+func DecodeInto[T any](r io.Reader, out *[]T, opts ...DecodeOption) error {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("dml: DecodeInto requires a struct type, got %v", typ)
+	}
+
+	tables, err := DecodeTable(r, opts...)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		for i, record := range table.Records {
+			var v T
+			if err := unmarshalRecord(record, reflect.ValueOf(&v).Elem()); err != nil {
+				return fmt.Errorf("dml: error decoding record %v of table %v: %w", i, table.Name, err)
+			}
+
+			*out = append(*out, v)
+		}
+	}
+
+	return nil
+}
+
+func unmarshalRecord(record Record, v reflect.Value) error {
+	typ := v.Type()
+
+	for i := range typ.NumField() {
+		structField := typ.Field(i)
+
+		name, ok := structField.Tag.Lookup("dml")
+		if !ok || !structField.IsExported() {
+			continue
+		}
+
+		value, ok := record[name]
+		if !ok {
+			return fmt.Errorf("field %q is missing from template", name)
+		}
+
+		if err := setField(v.Field(i), reflect.ValueOf(value)); err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(dst, src reflect.Value) error {
+	if !src.IsValid() {
+		return fmt.Errorf("value is nil")
+	}
+
+	// Only convert within a kind of value. reflect would otherwise happily turn an integer into a
+	// string, truncate a float into an integer or wrap an integer that doesn't fit.
+	srcKind, dstKind := kindOf(src.Kind()), kindOf(dst.Kind())
+	if srcKind == kindOther || srcKind != dstKind && !(srcKind.integer() && dstKind.integer()) {
+		return fmt.Errorf("cannot convert %v to %v", src.Type(), dst.Type())
+	}
+
+	if overflows(dst, src) {
+		return fmt.Errorf("value %v overflows %v", src, dst.Type())
+	}
+
+	dst.Set(src.Convert(dst.Type()))
+	return nil
+}
+
+type valueKind int
+
+const (
+	kindOther valueKind = iota
+	kindInt
+	kindUint
+	kindFloat
+	kindString
+)
+
+func (k valueKind) integer() bool {
+	return k == kindInt || k == kindUint
+}
+
+func kindOf(kind reflect.Kind) valueKind {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return kindInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return kindUint
+	case reflect.Float32, reflect.Float64:
+		return kindFloat
+	case reflect.String:
+		return kindString
+	default:
+		return kindOther
+	}
+}
+
+// overflows reports whether src can't be held by dst, src and dst must both be integers or both
+// be floats
+func overflows(dst, src reflect.Value) bool {
+	switch kindOf(src.Kind()) {
+	case kindInt:
+		v := src.Int()
+		if kindOf(dst.Kind()) == kindUint {
+			return v < 0 || dst.OverflowUint(uint64(v))
+		}
+		return dst.OverflowInt(v)
+	case kindUint:
+		v := src.Uint()
+		if kindOf(dst.Kind()) == kindInt {
+			return v > math.MaxInt64 || dst.OverflowInt(int64(v))
+		}
+		return dst.OverflowUint(v)
+	case kindFloat:
+		return dst.OverflowFloat(src.Float())
+	default:
+		return false
+	}
+}
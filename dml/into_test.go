@@ -0,0 +1,153 @@
+package dml
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type worldData struct {
+	SrcFileName string `dml:"SrcFileName"`
+	HeaderCRC   uint32 `dml:"HeaderCRC"`
+	FileType    int    `dml:"FileType"`
+	Ignored     string
+}
+
+func TestDecodeInto(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []worldData
+	require.NoError(t, DecodeInto(file, &records))
+
+	require.Len(t, records, 1)
+	assert.Equal(t, worldData{
+		SrcFileName: "Data/GameData/_Shared-WorldData.wad",
+		HeaderCRC:   2647210788,
+		FileType:    3,
+	}, records[0])
+}
+
+func TestDecodeIntoMissingField(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []struct {
+		Missing uint32 `dml:"Missing"`
+	}
+	assert.Error(t, DecodeInto(file, &records))
+}
+
+func TestDecodeIntoMismatchedType(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []struct {
+		HeaderCRC string `dml:"HeaderCRC"`
+	}
+	assert.Error(t, DecodeInto(file, &records))
+}
+
+func TestDecodeIntoOverflow(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []struct {
+		HeaderCRC int8 `dml:"HeaderCRC"`
+	}
+	assert.Error(t, DecodeInto(file, &records))
+}
+
+func TestDecodeIntoConversions(t *testing.T) {
+	encodeValue := func(t *testing.T, value any) *bytes.Buffer {
+		t.Helper()
+
+		var b bytes.Buffer
+		require.NoError(t, EncodeTable(&b, []Table{
+			{Name: "Values", Records: []Record{{"Value": value}}},
+		}))
+		return &b
+	}
+
+	t.Run("GIDIntoInt8", func(t *testing.T) {
+		var records []struct {
+			Value int8 `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, uint64(300)), &records))
+	})
+
+	t.Run("GIDIntoInt64", func(t *testing.T) {
+		var records []struct {
+			Value int64 `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, uint64(math.MaxUint64)), &records))
+	})
+
+	t.Run("NegativeIntoUnsigned", func(t *testing.T) {
+		var records []struct {
+			Value uint32 `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, int32(-1)), &records))
+	})
+
+	t.Run("FloatIntoInt", func(t *testing.T) {
+		var records []struct {
+			Value int `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, float32(1.5)), &records))
+	})
+
+	t.Run("IntIntoFloat", func(t *testing.T) {
+		var records []struct {
+			Value float64 `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, int32(1)), &records))
+	})
+
+	t.Run("DoubleIntoFloat32", func(t *testing.T) {
+		var records []struct {
+			Value float32 `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, math.MaxFloat64), &records))
+	})
+
+	t.Run("IntIntoString", func(t *testing.T) {
+		var records []struct {
+			Value string `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, int32(65)), &records))
+	})
+
+	t.Run("StringIntoInt", func(t *testing.T) {
+		var records []struct {
+			Value int `dml:"Value"`
+		}
+		assert.Error(t, DecodeInto(encodeValue(t, "65"), &records))
+	})
+
+	t.Run("Widening", func(t *testing.T) {
+		var records []struct {
+			Value int64 `dml:"Value"`
+		}
+		require.NoError(t, DecodeInto(encodeValue(t, uint16(math.MaxUint16)), &records))
+		require.Len(t, records, 1)
+		assert.Equal(t, int64(math.MaxUint16), records[0].Value)
+	})
+
+	t.Run("SignedIntoUnsigned", func(t *testing.T) {
+		var records []struct {
+			Value uint8 `dml:"Value"`
+		}
+		require.NoError(t, DecodeInto(encodeValue(t, int32(200)), &records))
+		require.Len(t, records, 1)
+		assert.Equal(t, uint8(200), records[0].Value)
+	})
+}
@@ -0,0 +1,34 @@
+package dml
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonTable struct {
+	Name    string   `json:"name"`
+	Records []Record `json:"records"`
+}
+
+// MarshalJSON encodes the table as an object with its name and records. Record fields are
+// written in name order.
+func (t Table) MarshalJSON() ([]byte, error) {
+	records := t.Records
+	if records == nil {
+		records = []Record{}
+	}
+
+	return json.Marshal(jsonTable{
+		Name:    t.Name,
+		Records: records,
+	})
+}
+
+// TablesToJSON writes tables to w as a JSON array.
+func TablesToJSON(w io.Writer, tables []Table) error {
+	if tables == nil {
+		tables = []Table{}
+	}
+
+	return json.NewEncoder(w).Encode(tables)
+}
@@ -0,0 +1,50 @@
+package dml
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableMarshalJSON(t *testing.T) {
+	table := Table{
+		Name: "Coordinates",
+		Records: []Record{
+			{"LocationX": float32(123.5), "Display": "Zauberer – Ünïcödé 🧙"},
+		},
+	}
+
+	data, err := json.Marshal(table)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Coordinates","records":[{"Display":"Zauberer – Ünïcödé 🧙","LocationX":123.5}]}`, string(data))
+
+	data, err = json.Marshal(Table{Name: "Empty"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Empty","records":[]}`, string(data))
+}
+
+func TestTablesToJSON(t *testing.T) {
+	file, err := os.Open("testdata/dml2.bin")
+	require.NoError(t, err)
+	defer file.Close()
+
+	tables, err := DecodeTable(file)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, TablesToJSON(&b, tables))
+
+	var decoded []struct {
+		Name    string           `json:"name"`
+		Records []map[string]any `json:"records"`
+	}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &decoded))
+	require.Len(t, decoded, 1)
+	assert.Equal(t, "_Shared-WorldData", decoded[0].Name)
+	assert.Equal(t, "Data/GameData/_Shared-WorldData.wad", decoded[0].Records[0]["SrcFileName"])
+	assert.Equal(t, float64(2647210788), decoded[0].Records[0]["HeaderCRC"])
+}
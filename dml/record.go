@@ -0,0 +1,47 @@
+package dml
+
+// Int returns the named field widened to an int64. It reports false if the field is absent or
+// isn't an INT, UINT, BYT, UBYT, SHRT or USHRT value.
+func (r Record) Int(name string) (int64, bool) {
+	switch v := r[name].(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// String returns the named field if it's a STR or WSTR value.
+func (r Record) String(name string) (string, bool) {
+	v, ok := r[name].(string)
+	return v, ok
+}
+
+// Float returns the named field widened to a float64. It reports false if the field is absent or
+// isn't a FLT or DBL value.
+func (r Record) Float(name string) (float64, bool) {
+	switch v := r[name].(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// GID returns the named field if it's a GID value.
+func (r Record) GID(name string) (uint64, bool) {
+	v, ok := r[name].(uint64)
+	return v, ok
+}
@@ -0,0 +1,59 @@
+package dml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAccessors(t *testing.T) {
+	record := Record{
+		"Int":   int32(-50),
+		"Byt":   int8(-3),
+		"Uint":  uint32(2647210788),
+		"Flt":   float32(123.5),
+		"Dbl":   float64(-45.25),
+		"Str":   "Test",
+		"Gid":   uint64(0xDEADBEEFCAFE),
+		"Other": []byte{0x1},
+	}
+
+	i, ok := record.Int("Int")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-50), i)
+
+	i, ok = record.Int("Byt")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-3), i)
+
+	i, ok = record.Int("Uint")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2647210788), i)
+
+	f, ok := record.Float("Flt")
+	assert.True(t, ok)
+	assert.Equal(t, 123.5, f)
+
+	f, ok = record.Float("Dbl")
+	assert.True(t, ok)
+	assert.Equal(t, -45.25, f)
+
+	s, ok := record.String("Str")
+	assert.True(t, ok)
+	assert.Equal(t, "Test", s)
+
+	gid, ok := record.GID("Gid")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0xDEADBEEFCAFE), gid)
+
+	_, ok = record.Int("Missing")
+	assert.False(t, ok)
+	_, ok = record.Int("Gid")
+	assert.False(t, ok)
+	_, ok = record.Float("Int")
+	assert.False(t, ok)
+	_, ok = record.String("Other")
+	assert.False(t, ok)
+	_, ok = record.GID("Uint")
+	assert.False(t, ok)
+}
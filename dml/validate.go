@@ -0,0 +1,44 @@
+package dml
+
+import "fmt"
+
+// GIDError reports a required GID field that is zero, missing or not a GID.
+type GIDError struct {
+	Table  string
+	Record int
+	Field  string
+}
+
+func (e *GIDError) Error() string {
+	return fmt.Sprintf("dml: table %v record %v: GID field %q is zero or missing", e.Table, e.Record, e.Field)
+}
+
+// ValidateGIDs checks that the GID fields named in required are non-zero in every record of
+// their tables. required maps a table name to the names of its required GID fields. A *GIDError
+// is returned for every violation.
+func ValidateGIDs(tables []Table, required map[string][]string) []error {
+	var errs []error
+
+	for _, table := range tables {
+		fields, ok := required[table.Name]
+		if !ok {
+			continue
+		}
+
+		for i, record := range table.Records {
+			for _, field := range fields {
+				if gid, ok := record[field].(uint64); ok && gid != 0 {
+					continue
+				}
+
+				errs = append(errs, &GIDError{
+					Table:  table.Name,
+					Record: i,
+					Field:  field,
+				})
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,35 @@
+package dml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGIDs(t *testing.T) {
+	tables := []Table{
+		{
+			Name: "Items",
+			Records: []Record{
+				{"ID": uint64(1), "Owner": uint64(2)},
+				{"ID": uint64(3), "Owner": uint64(0)},
+				{"ID": uint64(4)},
+			},
+		},
+		{
+			Name: "Unchecked",
+			Records: []Record{
+				{"ID": uint64(0)},
+			},
+		},
+	}
+
+	errs := ValidateGIDs(tables, map[string][]string{
+		"Items": {"ID", "Owner"},
+	})
+
+	assert.Equal(t, []error{
+		&GIDError{Table: "Items", Record: 1, Field: "Owner"},
+		&GIDError{Table: "Items", Record: 2, Field: "Owner"},
+	}, errs)
+}
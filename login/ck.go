@@ -2,6 +2,7 @@ package login
 
 import (
 	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 )
@@ -16,11 +17,19 @@ func GenerateCK1(password string, sid uint16, timeSecs uint32, timeMillis uint32
 	return sHash
 }
 
-// GenerateCK3 returns a ClientKey3 string derived from the inputs
-func GenerateCK3(password string, sid uint16, timeSecs uint32, timeMillis uint32) string {
+// GenerateCK3 returns a ClientKey3 string derived from the inputs. ck2 is the ClientKey2 the login
+// server issues after authenticating with CK1, it isn't derived by the client so there's no
+// GenerateCK2.
+func GenerateCK3(ck2 string, sid uint16, timeSecs uint32, timeMillis uint32) string {
 	s := salt(sid, timeSecs, timeMillis)
 
-	return secondaryEncrypt(password, s)
+	return secondaryEncrypt(ck2, s)
+}
+
+// CompareCK reports whether two client keys are equal. It takes the same time whatever the contents
+// of the keys, so should be used instead of == when checking a key submitted by a client.
+func CompareCK(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
 
 func salt(sid uint16, timeSecs uint32, timeMillis uint32) string {
@@ -19,3 +19,11 @@ func TestGenerateCK3(t *testing.T) {
 	expected := "ntaVuE1BT+8UZlrRAEHwVsYE0LVSYnduw0DCplF4ra2PATs+p1Bta/33QpDjJ5w1L7ROANmgF0m7FMtQncdthg=="
 	assert.Equal(t, expected, ck3)
 }
+
+func TestCompareCK(t *testing.T) {
+	ck1 := GenerateCK1("1", 3258, 1617815695, 805)
+
+	assert.True(t, CompareCK(ck1, GenerateCK1("1", 3258, 1617815695, 805)))
+	assert.False(t, CompareCK(ck1, GenerateCK1("2", 3258, 1617815695, 805)))
+	assert.False(t, CompareCK(ck1, ck1[:len(ck1)-1]))
+}
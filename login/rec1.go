@@ -7,11 +7,13 @@ import (
 	"golang.org/x/crypto/twofish"
 )
 
-func generateIV() []byte {
+// DeriveIV returns the 16 byte IV used with the key from DeriveKey. It doesn't depend on the
+// session, each byte counts down from 0xB6. The game builds a 32 byte IV the same way but Twofish
+// only uses as many bytes as its block size.
+func DeriveIV() []byte {
 	const ivConstant = 0xB6
 
-	// NOTE: Only 16 bytes of the IV are used
-	iv := make([]byte, 32)
+	iv := make([]byte, twofish.BlockSize)
 	for i := 0; i < len(iv); i++ {
 		iv[i] = ivConstant - byte(i)
 	}
@@ -19,7 +21,10 @@ func generateIV() []byte {
 	return iv
 }
 
-func generateKey(sid uint16, timeSecs uint32, timeMillis uint32) []byte {
+// DeriveKey returns the 32 byte Twofish key for a session. The key starts as bytes counting up
+// from 0x17, then the session ID, time in seconds and milliseconds from the session offer are
+// scattered over bytes 4 to 15.
+func DeriveKey(sid uint16, timeSecs uint32, timeMillis uint32) []byte {
 	const keyConstant = 0x17
 
 	key := make([]byte, 32)
@@ -47,14 +52,21 @@ func generateKey(sid uint16, timeSecs uint32, timeMillis uint32) []byte {
 	return key
 }
 
+// SessionParams holds the values agreed with the server during the session handshake
+type SessionParams struct {
+	SessionID  uint16
+	TimeSecs   uint32
+	TimeMillis uint32
+}
+
 // AuthenToken returns a token to be encrypted by the client in the authentication stage
 func AuthenToken(username string, ck1 string, sid uint16) []byte {
 	return []byte(fmt.Sprintf("%v %v %v", sid, username, ck1))
 }
 
 func xorRec1(buf []byte, sid uint16, timeSecs uint32, timeMillis uint32) []byte {
-	key := generateKey(sid, timeSecs, timeMillis)
-	iv := generateIV()[:16]
+	key := DeriveKey(sid, timeSecs, timeMillis)
+	iv := DeriveIV()
 
 	block, err := twofish.NewCipher(key)
 	if err != nil {
@@ -78,3 +90,13 @@ func EncryptRec1(plaintext []byte, sid uint16, timeSecs uint32, timeMillis uint3
 func DecryptRec1(rec1 []byte, sid uint16, timeSecs uint32, timeMillis uint32) []byte {
 	return xorRec1(rec1, sid, timeSecs, timeMillis)
 }
+
+// EncryptRec1P is EncryptRec1 with the session parameters taken from params
+func EncryptRec1P(plaintext []byte, params SessionParams) []byte {
+	return EncryptRec1(plaintext, params.SessionID, params.TimeSecs, params.TimeMillis)
+}
+
+// DecryptRec1P is DecryptRec1 with the session parameters taken from params
+func DecryptRec1P(rec1 []byte, params SessionParams) []byte {
+	return DecryptRec1(rec1, params.SessionID, params.TimeSecs, params.TimeMillis)
+}
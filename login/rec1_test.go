@@ -7,16 +7,16 @@ import (
 	"testing"
 )
 
-func TestGenerateIV(t *testing.T) {
-	iv := generateIV()[:16]
+func TestDeriveIV(t *testing.T) {
+	iv := DeriveIV()
 	enc := hex.EncodeToString(iv)
 
 	expected := "b6b5b4b3b2b1b0afaeadacabaaa9a8a7"
 	assert.Equal(t, expected, enc)
 }
 
-func TestGenerateKey(t *testing.T) {
-	key := generateKey(3258, 1617815695, 805)
+func TestDeriveKey(t *testing.T) {
+	key := DeriveKey(3258, 1617815695, 805)
 	enc := hex.EncodeToString(key)
 
 	expected := "1718191aba000c1e8f6d2122e86025032728292a2b2c2d2e2f30313233343536"
@@ -41,3 +41,17 @@ func TestDecryptRec1(t *testing.T) {
 	expected := "3258 1 +FO9W7DLYNuvLdwvnMaxtJrSD+/h7HHfpzSNKv6G4UomKKoy+uwknGbqrtz4KNHSIS6McowtSTXtQBwwq7bwSQ=="
 	assert.Equal(t, expected, dec)
 }
+
+func TestEncryptRec1P(t *testing.T) {
+	params := SessionParams{
+		SessionID:  3258,
+		TimeSecs:   1617815695,
+		TimeMillis: 805,
+	}
+
+	token := []byte("3258 1 token")
+	rec1 := EncryptRec1P(token, params)
+
+	assert.Equal(t, EncryptRec1(token, 3258, 1617815695, 805), rec1)
+	assert.Equal(t, token, DecryptRec1P(rec1, params))
+}
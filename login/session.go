@@ -0,0 +1,18 @@
+package login
+
+// Session holds a user's credentials and the parameters of the session they're logging in on
+type Session struct {
+	Username string
+	Password string
+	SessionParams
+}
+
+// Rec1 returns the encrypted authentication token to be sent as the EncryptedMessage of the
+// session accept. It generates CK1 from the password, builds the token from it and encrypts the
+// token with the session parameters.
+func (s Session) Rec1() []byte {
+	ck1 := GenerateCK1(s.Password, s.SessionID, s.TimeSecs, s.TimeMillis)
+	token := AuthenToken(s.Username, ck1, s.SessionID)
+
+	return EncryptRec1P(token, s.SessionParams)
+}
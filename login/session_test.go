@@ -0,0 +1,29 @@
+package login
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRec1(t *testing.T) {
+	session := Session{
+		Username: "1",
+		Password: "1",
+		SessionParams: SessionParams{
+			SessionID:  3258,
+			TimeSecs:   1617815695,
+			TimeMillis: 805,
+		},
+	}
+
+	rec1 := session.Rec1()
+
+	enc := base64.StdEncoding.EncodeToString(rec1)
+	expected := "VLZpUqHY04cULJ+dvYknBM2Y3xynINN3gB4svovYA0jzWUsVAXjdtz363K9pC049fhpK9zFjlGaC6awzXmUCeKMseu7+Bol3JiFmN46MAv6fOQ7pNvD6RFlpzzjZ8rQ="
+	assert.Equal(t, expected, enc)
+
+	expectedToken := "3258 1 +FO9W7DLYNuvLdwvnMaxtJrSD+/h7HHfpzSNKv6G4UomKKoy+uwknGbqrtz4KNHSIS6McowtSTXtQBwwq7bwSQ=="
+	assert.Equal(t, expectedToken, string(DecryptRec1P(rec1, session.SessionParams)))
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/cedws/w101-client-go/cmd"
+
+func main() {
+	cmd.Execute()
+}
@@ -0,0 +1,120 @@
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// maxBatchSize is the most message data WriteMessages puts in a single frame. Frames larger than
+// 0x7FFF bytes need the extended length header, so batches are kept under that by leaving room
+// for the frame header and terminator.
+const maxBatchSize = 0x7FFF - 4 - 1
+
+// OutgoingMessage is a message to be written by WriteMessages.
+type OutgoingMessage struct {
+	Service byte
+	Order   byte
+	Message Message
+}
+
+// WriteMessages queues several messages to be written, packing as many as fit into each frame.
+// Each message keeps its own DML header, so the combined size of a frame is the sum of each
+// message's packet plus 4 bytes, and is kept under 0x7FFB bytes so that frames never need the
+// extended length header. A message too large to share a frame is written in a frame of its own.
+// The receiver only reads past the first message of a frame if it opts in to batched reads, as
+// with WithBatchedReads and WithBatchedServerReads. It returns ErrClientClosed if the client has
+// been closed.
+func (c *Client) WriteMessages(msgs []OutgoingMessage) error {
+	var batch []byte
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		frame := &Frame{MessageData: batch}
+		batch = nil
+
+		return c.send(frame)
+	}
+
+	for _, msg := range msgs {
+		dml := DMLMessage{
+			ServiceID:   msg.Service,
+			OrderNumber: msg.Order,
+			Packet:      msg.Message.Marshal(),
+		}
+		data := dml.Marshal()
+
+		if len(batch)+len(data) > maxBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		batch = append(batch, data...)
+	}
+
+	return flush()
+}
+
+// decodeMessages decodes the DML messages in a frame's message data. Unless batched is set, the
+// frame is decoded as a single message and anything past its declared length is ignored.
+func decodeMessages(data []byte, batched bool) ([]DMLMessage, error) {
+	if batched {
+		return splitMessages(data)
+	}
+
+	var msg DMLMessage
+	if err := msg.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	return []DMLMessage{msg}, nil
+}
+
+// splitMessages decodes every DML message in a frame's message data. A frame normally holds one
+// message, followed by the frame's terminator, which is left on the end of its packet as with
+// DMLMessage.Unmarshal.
+func splitMessages(data []byte) ([]DMLMessage, error) {
+	var msgs []DMLMessage
+
+	for offset := 0; offset < len(data); {
+		rest := data[offset:]
+		if len(rest) < 4 {
+			return nil, &DecodeError{
+				Raw:    data,
+				Offset: offset,
+				Cause:  fmt.Errorf("invalid dml message, expected at least 4 bytes but got %v", len(rest)),
+			}
+		}
+
+		end := int(binary.LittleEndian.Uint16(rest[2:4]))
+		if end < 4 {
+			return nil, &DecodeError{
+				Raw:    data,
+				Offset: offset + 2,
+				Cause:  fmt.Errorf("invalid dml message, length %v is shorter than its header", end),
+			}
+		}
+
+		// The last message is followed by the frame terminator
+		if end+1 >= len(rest) {
+			var msg DMLMessage
+			if err := msg.Unmarshal(rest); err != nil {
+				return nil, err
+			}
+
+			return append(msgs, msg), nil
+		}
+
+		msgs = append(msgs, DMLMessage{
+			ServiceID:   rest[0],
+			OrderNumber: rest[1],
+			Packet:      rest[4:end],
+		})
+		offset += end
+	}
+
+	return msgs, nil
+}
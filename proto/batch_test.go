@@ -0,0 +1,190 @@
+package proto
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMessages(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	require.NoError(t, client.WriteMessages([]OutgoingMessage{
+		{Service: 5, Order: 1, Message: &testMessage{Value: 1}},
+		{Service: 5, Order: 2, Message: &testMessage{Value: 2}},
+		{Service: 6, Order: 1, Message: &testMessage{Value: 3}},
+	}))
+
+	for _, frame := range srv.collectFrames(time.Second) {
+		if frame.Control {
+			continue
+		}
+
+		msgs, err := splitMessages(frame.MessageData)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+
+		for i, expected := range []struct{ service, order byte }{{5, 1}, {5, 2}, {6, 1}} {
+			assert.Equal(t, expected.service, msgs[i].ServiceID)
+			assert.Equal(t, expected.order, msgs[i].OrderNumber)
+
+			var msg testMessage
+			require.NoError(t, msg.Unmarshal(msgs[i].Packet))
+			assert.Equal(t, uint32(i+1), msg.Value)
+		}
+		return
+	}
+
+	t.Fatal("batched frame was not received")
+}
+
+func TestWriteMessagesSplitsFrames(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	// Each message is 8 bytes, so this can't fit in one frame
+	msgs := make([]OutgoingMessage, maxBatchSize/8+1)
+	for i := range msgs {
+		msgs[i] = OutgoingMessage{Service: 5, Order: 1, Message: &testMessage{Value: uint32(i)}}
+	}
+	require.NoError(t, client.WriteMessages(msgs))
+
+	var received int
+	for _, frame := range srv.collectFrames(time.Second) {
+		if frame.Control {
+			continue
+		}
+
+		assert.True(t, len(frame.MessageData) <= maxBatchSize+1)
+
+		split, err := splitMessages(frame.MessageData)
+		require.NoError(t, err)
+		received += len(split)
+
+		if received == len(msgs) {
+			return
+		}
+	}
+
+	t.Fatalf("received %v of %v messages", received, len(msgs))
+}
+
+func TestReadBatchedFrame(t *testing.T) {
+	srv := startTestServer(t)
+
+	received := make(chan uint32, 8)
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) { received <- msg.Value })
+	RegisterMessageHandler(&router, 5, 2, func(msg testMessage) { received <- msg.Value })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), WithBatchedReads())
+	require.NoError(t, err)
+	defer client.Close()
+
+	var data []byte
+	for i, order := range []byte{1, 2, 1} {
+		dml := DMLMessage{ServiceID: 5, OrderNumber: order, Packet: (&testMessage{Value: uint32(i)}).Marshal()}
+		data = append(data, dml.Marshal()...)
+	}
+
+	writer := FrameWriter{writer: <-srv.conns}
+	require.NoError(t, writer.Write(&Frame{MessageData: data}))
+
+	for i := range uint32(3) {
+		select {
+		case v := <-received:
+			assert.Equal(t, i, v)
+		case <-time.After(5 * time.Second):
+			t.Fatal("batched message was not handled")
+		}
+	}
+}
+
+func TestReadFrameTrailingPadding(t *testing.T) {
+	srv := startTestServer(t)
+
+	received := make(chan uint32, 8)
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) { received <- msg.Value })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Without batched reads, bytes past the message's declared length aren't parsed as messages
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: (&testMessage{Value: 7}).Marshal()}
+	data := append(dml.Marshal(), 0xAA, 0xBB, 0xCC, 0xDD, 0xEE)
+
+	writer := FrameWriter{writer: <-srv.conns}
+	require.NoError(t, writer.Write(&Frame{MessageData: data}))
+
+	select {
+	case v := <-received:
+		assert.Equal(t, uint32(7), v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("padded message was not handled")
+	}
+
+	select {
+	case v := <-received:
+		t.Fatalf("padding was handled as message %v", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, client.Err())
+	select {
+	case <-client.Done():
+		t.Fatal("client shut down")
+	default:
+	}
+}
+
+func TestSplitMessagesInvalid(t *testing.T) {
+	// Second message declares a length shorter than its header
+	data := append(DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: []byte{0x1}}.Marshal(), 5, 1, 2, 0, 0xAA, 0xBB, 0)
+
+	_, err := splitMessages(data)
+	assert.Error(t, err)
+}
+
+func TestSplitMessagesHeaderOnly(t *testing.T) {
+	for _, length := range []uint16{0, 2, 0xFFFF} {
+		data := binary.LittleEndian.AppendUint16([]byte{5, 1}, length)
+
+		_, err := splitMessages(data)
+
+		var decodeErr *DecodeError
+		assert.True(t, errors.As(err, &decodeErr), "length %v", length)
+	}
+}
+
+func FuzzSplitMessages(f *testing.F) {
+	var batch []byte
+	for i := range 2 {
+		dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: (&testMessage{Value: uint32(i)}).Marshal()}
+		batch = append(batch, dml.Marshal()...)
+	}
+
+	f.Add(append(batch, 0))
+	f.Add([]byte{5, 1, 0x00, 0x00})
+	f.Add([]byte{5, 1, 0xFF, 0xFF})
+	f.Add([]byte{5, 1, 0x04, 0x00, 5, 1, 0x00, 0x00})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		splitMessages(data)
+		decodeMessages(data, false)
+	})
+}
@@ -2,17 +2,44 @@ package control
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 )
 
+// ErrInvalidSignature is returned by SessionOffer.Verify when an offer isn't signed by the
+// expected key.
+var ErrInvalidSignature = errors.New("control: invalid session offer signature")
+
+// Opcode identifies the type of a control frame
+type Opcode uint8
+
 const (
-	PktSessionOffer        byte = 0x0
-	PktSessionKeepAlive    byte = 0x3
-	PktSessionKeepAliveRsp byte = 0x4
-	PktSessionAccept       byte = 0x5
+	PktSessionOffer        Opcode = 0x0
+	PktSessionKeepAlive    Opcode = 0x3
+	PktSessionKeepAliveRsp Opcode = 0x4
+	PktSessionAccept       Opcode = 0x5
 )
 
+func (o Opcode) String() string {
+	switch o {
+	case PktSessionOffer:
+		return "SessionOffer"
+	case PktSessionKeepAlive:
+		return "SessionKeepAlive"
+	case PktSessionKeepAliveRsp:
+		return "SessionKeepAliveRsp"
+	case PktSessionAccept:
+		return "SessionAccept"
+	default:
+		return fmt.Sprintf("Opcode(0x%02x)", uint8(o))
+	}
+}
+
 type SessionOffer struct {
 	SessionID  uint16
 	TimeSecs   uint32
@@ -89,6 +116,27 @@ func (s *SessionOffer) Unmarshal(data []byte) error {
 	return nil
 }
 
+// Verify checks that the offer's Signature is a PKCS #1 v1.5 signature of the SHA-256 hash of its
+// RawMessage made with the private key matching pub. KingsIsle don't publish their public key,
+// it's embedded in the game client and has to be extracted from there.
+//
+// The signature scheme is an assumption. All that's known is that offers end in 256 bytes of
+// signature, which fits a 2048-bit RSA key, and the scheme hasn't been checked against an offer
+// captured from a real server. Verify failing on real offers most likely means the assumption is
+// wrong rather than that the offer is forged.
+func (s *SessionOffer) Verify(pub *rsa.PublicKey) error {
+	if len(s.Signature) == 0 {
+		return fmt.Errorf("%w: offer is unsigned", ErrInvalidSignature)
+	}
+
+	hash := sha256.Sum256(s.RawMessage)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], s.Signature); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
 type ClientKeepAlive struct {
 	SessionID           uint16
 	TimeMillis          uint16
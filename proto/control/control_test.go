@@ -0,0 +1,53 @@
+package control
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpcodeString(t *testing.T) {
+	assert.Equal(t, "SessionOffer", PktSessionOffer.String())
+	assert.Equal(t, "SessionKeepAliveRsp", PktSessionKeepAliveRsp.String())
+	assert.Equal(t, "Opcode(0x7f)", Opcode(0x7F).String())
+}
+
+// TestSessionOfferVerify only checks offers signed with the scheme Verify assumes, there's no
+// captured real offer to check it against yet.
+func TestSessionOfferVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	rawMessage := []byte("offer")
+	hash := sha256.Sum256(rawMessage)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+
+	sent := SessionOffer{
+		SessionID:  3258,
+		TimeSecs:   1617815695,
+		TimeMillis: 805,
+		RawMessage: rawMessage,
+		Signature:  signature,
+	}
+
+	var offer SessionOffer
+	require.NoError(t, offer.Unmarshal(sent.Marshal()))
+	assert.NoError(t, offer.Verify(&key.PublicKey))
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	assert.True(t, errors.Is(offer.Verify(&other.PublicKey), ErrInvalidSignature))
+
+	offer.RawMessage = []byte("forged")
+	assert.True(t, errors.Is(offer.Verify(&key.PublicKey), ErrInvalidSignature))
+
+	unsigned := SessionOffer{SessionID: 3258}
+	assert.True(t, errors.Is(unsigned.Verify(&key.PublicKey), ErrInvalidSignature))
+}
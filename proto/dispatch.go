@@ -0,0 +1,40 @@
+package proto
+
+type routedMessage struct {
+	frame *Frame
+	dml   DMLMessage
+}
+
+// startHandlerWorkers starts n goroutines routing messages and returns a function which queues a
+// message on the goroutine responsible for its service and order.
+func (c *Client) startHandlerWorkers(n int) func(*Frame, DMLMessage) bool {
+	queues := make([]chan routedMessage, n)
+	for i := range queues {
+		queues[i] = make(chan routedMessage, c.opts.channelBuffer)
+		go c.handlerWorker(queues[i])
+	}
+
+	return func(frame *Frame, d DMLMessage) bool {
+		queue := queues[(int(d.ServiceID)<<8|int(d.OrderNumber))%n]
+
+		select {
+		case queue <- routedMessage{frame, d}:
+			return true
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+func (c *Client) handlerWorker(queue chan routedMessage) {
+	for {
+		select {
+		case msg := <-queue:
+			if !c.routeMessage(msg.frame, msg.dml) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
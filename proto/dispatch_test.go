@@ -0,0 +1,63 @@
+package proto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHandlerConcurrency(t *testing.T) {
+	srv := startTestServer(t)
+
+	var (
+		release = make(chan struct{})
+		fast    = make(chan uint32, 16)
+		ordered = make(chan uint32, 16)
+	)
+	defer close(release)
+
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(testMessage) {
+		<-release
+	})
+	RegisterMessageHandler(&router, 5, 2, func(msg testMessage) {
+		fast <- msg.Value
+	})
+	RegisterMessageHandler(&router, 5, 3, func(msg testMessage) {
+		ordered <- msg.Value
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), WithHandlerConcurrency(4))
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn := <-srv.conns
+	sendTestMessage(t, conn, 5, 1, &testMessage{Value: 1})
+	sendTestMessage(t, conn, 5, 2, &testMessage{Value: 2})
+
+	// The slow handler for order 1 mustn't hold up order 2
+	select {
+	case v := <-fast:
+		assert.Equal(t, uint32(2), v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow handler stalled unrelated message")
+	}
+
+	for i := range uint32(10) {
+		sendTestMessage(t, conn, 5, 3, &testMessage{Value: i})
+	}
+	for i := range uint32(10) {
+		select {
+		case v := <-ordered:
+			assert.Equal(t, i, v)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not handled")
+		}
+	}
+}
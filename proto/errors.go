@@ -0,0 +1,75 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrClientClosed is returned when writing to a client that has been closed.
+var ErrClientClosed = errors.New("proto: client closed")
+
+// ErrReadTimeout is the cause of a connection shutting down when no frame was received within
+// the read timeout, see WithReadTimeout and WithLivenessCheck.
+var ErrReadTimeout = errors.New("proto: no frame received within read timeout")
+
+// ErrConnectionDead is the cause of a connection shutting down when it failed the liveness check
+// set with WithLivenessCheck. It wraps the ErrReadTimeout that caused it.
+var ErrConnectionDead = errors.New("proto: connection dead")
+
+// DecodeError is returned when a frame or message fails to decode. Raw holds the bytes that were
+// being decoded and Offset the position in Raw at which decoding failed. Offset is zero when a
+// message's Unmarshal method failed, as it doesn't report where.
+type DecodeError struct {
+	Raw    []byte
+	Offset int
+	Cause  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode failed at offset %v: %v", e.Offset, e.Cause)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Cause
+}
+
+// HandlerError is returned from Client.Err when a message handler or middleware failed, shutting
+// the client down.
+type HandlerError struct {
+	Service byte
+	Order   byte
+	Err     error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler for service %v order %v failed: %v", e.Service, e.Order, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned from MessageRouter.Handle when a handler or middleware panics.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// onlyPanics reports whether err consists only of PanicErrors.
+func onlyPanics(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !onlyPanics(err) {
+				return false
+			}
+		}
+		return true
+	}
+
+	_, ok := err.(*PanicError)
+	return ok
+}
@@ -2,19 +2,52 @@ package proto
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+
+	"github.com/cedws/w101-client-go/proto/control"
+)
+
+const (
+	headerMagic uint16 = 0xF00D
+	// defaultMaxFrameSize is the largest frame FrameReader accepts unless told otherwise
+	defaultMaxFrameSize = 16 << 20
 )
 
-const headerMagic uint16 = 0xF00D
+// ErrFrameTooLarge is returned by FrameReader when a frame's header declares a length greater
+// than its MaxFrameSize.
+var ErrFrameTooLarge = errors.New("proto: frame too large")
 
 type FrameReader struct {
 	Reader io.Reader
+	// Magic is the value expected at the start of each frame, 0xF00D if unset
+	Magic uint16
+	// MaxFrameSize is the largest frame length accepted from a header, 16MiB if unset. Frames
+	// declaring a greater length are rejected before any memory is allocated for them.
+	MaxFrameSize uint32
+	// ReuseBuffers makes Read take frame buffers from a pool rather than allocating them. The
+	// caller owns each frame's MessageData until it calls Frame.Release, after which the buffer
+	// may be handed to another frame.
+	ReuseBuffers bool
 }
 
 type FrameWriter struct {
 	writer io.Writer
+	// Magic is the value written at the start of each frame, 0xF00D if unset
+	Magic uint16
+}
+
+// NewFrameReader returns a FrameReader reading frames from r with the default magic and maximum
+// frame size.
+func NewFrameReader(r io.Reader) FrameReader {
+	return FrameReader{Reader: r}
+}
+
+// NewFrameWriter returns a FrameWriter writing frames to w with the default magic.
+func NewFrameWriter(w io.Writer) FrameWriter {
+	return FrameWriter{writer: w}
 }
 
 type frameReadWriter struct {
@@ -22,19 +55,50 @@ type frameReadWriter struct {
 	FrameWriter
 }
 
+// Opcode identifies the type of a control frame, see the constants in the control package
+type Opcode = control.Opcode
+
 type Frame struct {
 	Control     bool
-	Opcode      uint8
+	Opcode      Opcode
 	MessageData []byte
+
+	// buf is the pooled buffer MessageData was read into, if any
+	buf *[]byte
+}
+
+// Release returns the frame's buffer to the pool if it was read by a FrameReader with
+// ReuseBuffers set, and clears MessageData. Neither MessageData nor anything sliced from it, such
+// as the Packet of a DMLMessage, may be used after Release. It's a no-op for other frames.
+func (f *Frame) Release() {
+	if f.buf == nil {
+		return
+	}
+
+	putBuffer(f.buf)
+	f.buf = nil
+	f.MessageData = nil
+}
+
+func frameMagic(magic uint16) uint16 {
+	if magic == 0 {
+		return headerMagic
+	}
+
+	return magic
 }
 
 func (f *Frame) Unmarshal(data []byte) error {
 	if len(data) < 5 {
-		return fmt.Errorf("invalid frame, expected at least 5 bytes but got %v", len(data))
+		return &DecodeError{
+			Raw:    data,
+			Offset: len(data),
+			Cause:  fmt.Errorf("invalid frame, expected at least 5 bytes but got %v", len(data)),
+		}
 	}
 
 	f.Control = data[0] == 0x1
-	f.Opcode = data[1]
+	f.Opcode = Opcode(data[1])
 	f.MessageData = data[4:]
 
 	return nil
@@ -45,7 +109,7 @@ func (f *Frame) Marshal() []byte {
 	if f.Control {
 		buf[0] = 0x1
 	}
-	buf[1] = f.Opcode
+	buf[1] = uint8(f.Opcode)
 	copy(buf[4:], f.MessageData)
 
 	return buf
@@ -60,8 +124,11 @@ func (r *FrameReader) Read() (*Frame, error) {
 	if err := binary.Read(r.Reader, binary.LittleEndian, &magic); err != nil {
 		return nil, err
 	}
-	if magic != headerMagic {
-		return nil, fmt.Errorf("invalid frame, expected %v in header but got %v", headerMagic, magic)
+	if expected := frameMagic(r.Magic); magic != expected {
+		return nil, &DecodeError{
+			Raw:   binary.LittleEndian.AppendUint16(nil, magic),
+			Cause: fmt.Errorf("invalid frame, expected %v in header but got %v", expected, magic),
+		}
 	}
 
 	if err := binary.Read(r.Reader, binary.LittleEndian, &length); err != nil {
@@ -75,15 +142,37 @@ func (r *FrameReader) Read() (*Frame, error) {
 		}
 	}
 
-	rawFrame := make([]byte, realLength)
-	if _, err := io.ReadFull(r.Reader, rawFrame); err != nil {
+	maxSize := r.MaxFrameSize
+	if maxSize == 0 {
+		maxSize = defaultMaxFrameSize
+	}
+	if realLength > maxSize {
+		return nil, fmt.Errorf("%w: header declares %v bytes but max is %v", ErrFrameTooLarge, realLength, maxSize)
+	}
+
+	var buf *[]byte
+	if r.ReuseBuffers {
+		buf = getBuffer(int(realLength))
+	} else {
+		rawFrame := make([]byte, realLength)
+		buf = &rawFrame
+	}
+
+	if _, err := io.ReadFull(r.Reader, *buf); err != nil {
+		if r.ReuseBuffers {
+			putBuffer(buf)
+		}
 		return nil, err
 	}
 
+	// The buffer isn't returned to the pool on a decode error as the error holds on to it
 	frame := &Frame{}
-	if err := frame.Unmarshal(rawFrame); err != nil {
+	if err := frame.Unmarshal(*buf); err != nil {
 		return nil, err
 	}
+	if r.ReuseBuffers {
+		frame.buf = buf
+	}
 
 	return frame, nil
 }
@@ -91,11 +180,13 @@ func (r *FrameReader) Read() (*Frame, error) {
 func (w *FrameWriter) Write(frame *Frame) error {
 	rawFrame := frame.Marshal()
 
-	if err := binary.Write(w.writer, binary.LittleEndian, headerMagic); err != nil {
+	if err := binary.Write(w.writer, binary.LittleEndian, frameMagic(w.Magic)); err != nil {
 		return err
 	}
 
-	if len(rawFrame) > 0x7FFF {
+	// The length includes the trailing NUL and must stay below 0x8000, which marks an extended
+	// length
+	if len(rawFrame)+1 > 0x7FFF {
 		if len(rawFrame) > math.MaxUint32 {
 			return fmt.Errorf("frame too large, max size is %v but got %v", math.MaxUint32, len(rawFrame))
 		}
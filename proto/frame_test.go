@@ -0,0 +1,248 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameUnmarshalDecodeError(t *testing.T) {
+	raw := []byte{0x1, 0x2, 0x0}
+
+	var frame Frame
+	err := frame.Unmarshal(raw)
+
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, raw, decodeErr.Raw)
+	assert.Equal(t, len(raw), decodeErr.Offset)
+}
+
+func TestFrameReaderBadMagic(t *testing.T) {
+	reader := FrameReader{Reader: bytes.NewReader([]byte{0xEF, 0xBE, 0x05, 0x00})}
+
+	_, err := reader.Read()
+
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, []byte{0xEF, 0xBE}, decodeErr.Raw)
+}
+
+func TestFrameCustomMagic(t *testing.T) {
+	const magic = 0xBEEF
+
+	var b bytes.Buffer
+
+	writer := FrameWriter{writer: &b, Magic: magic}
+	frame := &Frame{Opcode: 0x1, MessageData: []byte{0xAA, 0xBB}}
+	require.NoError(t, writer.Write(frame))
+	assert.Equal(t, []byte{0xEF, 0xBE}, b.Bytes()[:2])
+
+	reader := FrameReader{Reader: bytes.NewReader(b.Bytes()), Magic: magic}
+	received, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, frame.Opcode, received.Opcode)
+
+	reader = FrameReader{Reader: bytes.NewReader(b.Bytes())}
+	_, err = reader.Read()
+
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, []byte{0xEF, 0xBE}, decodeErr.Raw)
+}
+
+func TestFrameReaderMaxFrameSize(t *testing.T) {
+	// Extended length header declaring a 4GiB frame with no body following it
+	oversized := []byte{0x0D, 0xF0, 0x00, 0x80, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	reader := FrameReader{Reader: bytes.NewReader(oversized)}
+	_, err := reader.Read()
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+
+	var b bytes.Buffer
+	writer := FrameWriter{writer: &b}
+	require.NoError(t, writer.Write(&Frame{Opcode: 0x1, MessageData: make([]byte, 64)}))
+
+	reader = FrameReader{Reader: bytes.NewReader(b.Bytes()), MaxFrameSize: 32}
+	_, err = reader.Read()
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+
+	reader = FrameReader{Reader: bytes.NewReader(b.Bytes()), MaxFrameSize: 128}
+	_, err = reader.Read()
+	assert.NoError(t, err)
+}
+
+func TestNewFrameReadWriter(t *testing.T) {
+	var b bytes.Buffer
+
+	writer := NewFrameWriter(&b)
+	frame := &Frame{Control: true, Opcode: 0x5, MessageData: []byte{0x1, 0x2, 0x3}}
+	require.NoError(t, writer.Write(frame))
+
+	reader := NewFrameReader(&b)
+	received, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, frame.Control, received.Control)
+	assert.Equal(t, frame.Opcode, received.Opcode)
+	// FrameReader keeps the trailing terminator byte written by FrameWriter
+	assert.Equal(t, append(frame.MessageData, 0), received.MessageData)
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataLen  int
+		extended bool
+	}{
+		{name: "empty", dataLen: 0},
+		{name: "small", dataLen: 16},
+		// Opcode header, data and NUL come to 0x7FFF, the largest short length
+		{name: "largest short length", dataLen: 0x7FFF - 4 - 1},
+		{name: "smallest extended length", dataLen: 0x7FFF - 4, extended: true},
+		{name: "large", dataLen: 1 << 20, extended: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.dataLen)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			frame := &Frame{Control: true, Opcode: 0x3, MessageData: data}
+
+			var b bytes.Buffer
+			writer := NewFrameWriter(&b)
+			require.NoError(t, writer.Write(frame))
+
+			raw := b.Bytes()
+			bodyLen := 4 + tt.dataLen + 1
+			if tt.extended {
+				assert.Equal(t, []byte{0x00, 0x80}, raw[2:4])
+				assert.Equal(t, binary.LittleEndian.AppendUint32(nil, uint32(bodyLen)), raw[4:8])
+				assert.Len(t, raw, 8+bodyLen)
+			} else {
+				assert.Equal(t, binary.LittleEndian.AppendUint16(nil, uint16(bodyLen)), raw[2:4])
+				assert.Len(t, raw, 4+bodyLen)
+			}
+			assert.Equal(t, byte(0), raw[len(raw)-1])
+
+			reader := NewFrameReader(bytes.NewReader(raw))
+			received, err := reader.Read()
+			require.NoError(t, err)
+			assert.Equal(t, frame.Control, received.Control)
+			assert.Equal(t, frame.Opcode, received.Opcode)
+			assert.Equal(t, append(data, 0), received.MessageData)
+
+			_, err = reader.Read()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func FuzzFrameReaderRead(f *testing.F) {
+	var b bytes.Buffer
+	writer := NewFrameWriter(&b)
+	writer.Write(&Frame{Opcode: 0x1, MessageData: []byte{0xAA, 0xBB}})
+	writer.Write(&Frame{Control: true, Opcode: 0x3, MessageData: make([]byte, 0x7FFF)})
+
+	f.Add(b.Bytes())
+	f.Add([]byte{0x0D, 0xF0, 0x00, 0x80, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte{0x0D, 0xF0, 0x01, 0x00, 0x00})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := NewFrameReader(bytes.NewReader(data))
+		reader.MaxFrameSize = 1 << 16
+
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestFrameReaderReuseBuffers(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewFrameWriter(&b)
+	require.NoError(t, writer.Write(&Frame{Opcode: 0x1, MessageData: []byte{0xAA, 0xBB}}))
+	require.NoError(t, writer.Write(&Frame{Opcode: 0x2, MessageData: []byte{0xCC}}))
+
+	reader := NewFrameReader(&b)
+	reader.ReuseBuffers = true
+
+	frame, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xAA, 0xBB, 0x0}, frame.MessageData)
+
+	frame.Release()
+	assert.Nil(t, frame.MessageData)
+	// A second release is a no-op
+	frame.Release()
+
+	frame, err = reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, Opcode(0x2), frame.Opcode)
+	assert.Equal(t, []byte{0xCC, 0x0}, frame.MessageData)
+	frame.Release()
+}
+
+func TestSizeClass(t *testing.T) {
+	tests := []struct {
+		n      int
+		class  int
+		pooled bool
+	}{
+		{n: 0, class: 0, pooled: true},
+		{n: 512, class: 0, pooled: true},
+		{n: 513, class: 1, pooled: true},
+		{n: 1 << 16, class: maxPooledClass - minPooledClass, pooled: true},
+		{n: 1<<16 + 1, pooled: false},
+	}
+
+	for _, tt := range tests {
+		class, ok := sizeClass(tt.n)
+		assert.Equal(t, tt.pooled, ok, "n=%v", tt.n)
+		if tt.pooled {
+			assert.Equal(t, tt.class, class, "n=%v", tt.n)
+		}
+	}
+}
+
+func BenchmarkFrameReaderRead(b *testing.B) {
+	var buf bytes.Buffer
+	writer := NewFrameWriter(&buf)
+	writer.Write(&Frame{Opcode: 0x1, MessageData: make([]byte, 4096)})
+	raw := buf.Bytes()
+
+	for _, reuse := range []bool{false, true} {
+		name := "Allocate"
+		if reuse {
+			name = "ReuseBuffers"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			src := bytes.NewReader(raw)
+			reader := NewFrameReader(src)
+			reader.ReuseBuffers = reuse
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+
+			for range b.N {
+				src.Reset(raw)
+
+				frame, err := reader.Read()
+				if err != nil {
+					b.Fatal(err)
+				}
+				frame.Release()
+			}
+		})
+	}
+}
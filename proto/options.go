@@ -0,0 +1,277 @@
+package proto
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultChannelBuffer = 8
+
+type Option func(*clientOptions)
+
+// ContextDialer opens connections for Dial. It's satisfied by *net.Dialer and by the dialers in
+// golang.org/x/net/proxy, so connections can be made through a SOCKS5 proxy or any other
+// transport that returns a net.Conn. Dial always asks for network "tcp".
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+type clientOptions struct {
+	heartbeat         bool
+	heartbeatInterval time.Duration
+	now               func() time.Time
+
+	channelBuffer int
+	dialer        ContextDialer
+	tlsConfig     *tls.Config
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	livenessMiss int
+
+	backoff     Backoff
+	onReconnect func(*Client)
+
+	onUnhandled func(*Frame)
+
+	handlerConcurrency int
+
+	onPanic func(*HandlerError)
+
+	onDecodeError func(*Frame, error)
+
+	credentials *credentials
+
+	onRawFrame func(*Frame)
+
+	maxFrameSize uint32
+
+	serverKey *rsa.PublicKey
+
+	frameMagic uint16
+
+	rateLimit rate.Limit
+	rateBurst int
+
+	tracer Tracer
+
+	batchedReads bool
+}
+
+type credentials struct {
+	username string
+	password string
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		heartbeat:         true,
+		heartbeatInterval: heartbeatInterval,
+		now:               time.Now,
+		channelBuffer:     defaultChannelBuffer,
+		dialer:            &net.Dialer{},
+	}
+}
+
+// WithNoHeartbeat stops the client from sending its own keepalives. The client will still
+// respond to keepalives sent by the server.
+func WithNoHeartbeat() Option {
+	return func(o *clientOptions) {
+		o.heartbeat = false
+	}
+}
+
+// WithHeartbeatInterval sets how often the client sends keepalives. The default is 10 seconds.
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(o *clientOptions) {
+		o.heartbeatInterval = d
+	}
+}
+
+// WithChannelBuffer sets the size of the queues between the connection and the goroutines
+// handling frames. Writes block once the outgoing queue is full. The default is 8.
+func WithChannelBuffer(n int) Option {
+	return func(o *clientOptions) {
+		o.channelBuffer = n
+	}
+}
+
+// WithDialer sets the dialer used to connect to the server, including when reconnecting. TLS set
+// by WithTLS is layered over the connection the dialer returns. The default is a zero
+// net.Dialer, which is also used if dialer is nil.
+func WithDialer(dialer ContextDialer) Option {
+	return func(o *clientOptions) {
+		o.dialer = dialer
+	}
+}
+
+// WithTLS makes Dial wrap the connection in TLS using config. If config doesn't set a ServerName,
+// the host of the remote address is used.
+func WithTLS(config *tls.Config) Option {
+	return func(o *clientOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithReadTimeout shuts the connection down if no frame is read within d. The server answers the
+// client's keepalives, so d must be longer than the heartbeat interval unless heartbeats are
+// disabled. The default is no timeout.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *clientOptions) {
+		o.readTimeout = d
+	}
+}
+
+// WithLivenessCheck treats the connection as dead once missed heartbeat intervals pass without a
+// frame being received from the server, which catches a server that went away without closing
+// the connection, which then fails with ErrConnectionDead. It's a read timeout that follows the
+// heartbeat interval, so it can't be used together with WithReadTimeout. missed must be at least
+// 2 so that the server has time to answer keepalives.
+func WithLivenessCheck(missed int) Option {
+	return func(o *clientOptions) {
+		o.livenessMiss = missed
+	}
+}
+
+// WithWriteTimeout shuts the connection down if writing a frame takes longer than d. The default
+// is no timeout.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *clientOptions) {
+		o.writeTimeout = d
+	}
+}
+
+// WithHandlerConcurrency routes messages on n goroutines so that a slow handler doesn't hold up
+// messages for other handlers. Messages with the same service and order are always handled by
+// the same goroutine, so they're handled one at a time and in the order they were received.
+// There are no ordering guarantees between messages with a different service or order. The
+// default is 1, which handles every message in order.
+func WithHandlerConcurrency(n int) Option {
+	return func(o *clientOptions) {
+		o.handlerConcurrency = n
+	}
+}
+
+// WithReconnect makes the client redial the server and negotiate a new session when its
+// connection fails, waiting between attempts as given by backoff. Messages queued while
+// reconnecting are written once a new session is established.
+func WithReconnect(backoff Backoff) Option {
+	return func(o *clientOptions) {
+		o.backoff = backoff
+	}
+}
+
+// OnReconnect sets a callback that's run each time the client reconnects, before any queued
+// messages are written. It can be used to authenticate the new session: messages it writes are
+// sent straight away, ahead of those queued while reconnecting. Frames from the server aren't
+// read until it returns, so it mustn't wait for a response.
+func OnReconnect(fn func(*Client)) Option {
+	return func(o *clientOptions) {
+		o.onReconnect = fn
+	}
+}
+
+// OnUnhandled sets a callback that's run for control frames the client doesn't understand and
+// for messages that have no registered handlers. It's only for observing traffic, the frame
+// isn't otherwise processed.
+func OnUnhandled(fn func(*Frame)) Option {
+	return func(o *clientOptions) {
+		o.onUnhandled = fn
+	}
+}
+
+// OnPanic sets a callback that's run when a handler or middleware panics. The error wraps a
+// PanicError. A panic never shuts the client down, other handlers for the message still run.
+func OnPanic(fn func(*HandlerError)) Option {
+	return func(o *clientOptions) {
+		o.onPanic = fn
+	}
+}
+
+// OnDecodeError sets a callback that's run when a message frame or a message in it fails to
+// decode, instead of the failure shutting the client down. The error is or wraps a DecodeError.
+// Handling carries on with the next message, or the next frame if the frame couldn't be decoded.
+// By default a decode failure shuts the client down, and the error is available from Client.Err.
+func OnDecodeError(fn func(*Frame, error)) Option {
+	return func(o *clientOptions) {
+		o.onDecodeError = fn
+	}
+}
+
+// WithCredentials makes the client authenticate when accepting a session. CK1 is generated from
+// password and the parameters of each session offered, including after reconnecting, and the
+// authentication token built from it is encrypted with the session's rec1 key and sent in the
+// SessionAccept.
+func WithCredentials(username, password string) Option {
+	return func(o *clientOptions) {
+		o.credentials = &credentials{username, password}
+	}
+}
+
+// OnRawFrame sets a callback that's run for every frame the client reads, including those read
+// during the session handshake, before the frame is handled. It's called from the goroutine
+// reading the connection, so a slow callback holds up reading. Frames can be written as-is with
+// Client.WriteRawFrame.
+func OnRawFrame(fn func(*Frame)) Option {
+	return func(o *clientOptions) {
+		o.onRawFrame = fn
+	}
+}
+
+// WithMaxFrameSize sets the largest frame the client will read. A frame declaring a greater
+// length fails the connection with ErrFrameTooLarge. The default is 16MiB.
+func WithMaxFrameSize(n uint32) Option {
+	return func(o *clientOptions) {
+		o.maxFrameSize = n
+	}
+}
+
+// WithServerKey makes the client verify every session offer against pub with
+// control.SessionOffer.Verify. Connecting fails if the first offer doesn't verify, and the client
+// is shut down if a later one doesn't. See Verify for where to find the key, and for the caveat
+// that its signature scheme is unconfirmed.
+func WithServerKey(pub *rsa.PublicKey) Option {
+	return func(o *clientOptions) {
+		o.serverKey = pub
+	}
+}
+
+// WithFrameMagic sets the magic value that starts every frame, for servers that don't use the
+// default of 0xF00D. Frames read with a different magic fail the connection.
+func WithFrameMagic(magic uint16) Option {
+	return func(o *clientOptions) {
+		o.frameMagic = magic
+	}
+}
+
+// WithRateLimit limits how fast the client writes messages to r frames per second, with bursts of
+// up to burst frames. Control frames such as keepalives bypass the limit so that a busy client
+// doesn't time out. Writing a message blocks until the limit allows it to be queued. A frame
+// written with WriteMessages counts once however many messages it holds. The default is no limit.
+func WithRateLimit(r rate.Limit, burst int) Option {
+	return func(o *clientOptions) {
+		o.rateLimit = r
+		o.rateBurst = burst
+	}
+}
+
+// WithTracer sets a tracer that spans are started with, see Tracer. The default is no tracing.
+func WithTracer(tracer Tracer) Option {
+	return func(o *clientOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithBatchedReads makes the client decode every message packed into an inbound frame, as
+// written by WriteMessages. By default a frame is decoded as a single message and any bytes past
+// its declared length are ignored.
+func WithBatchedReads() Option {
+	return func(o *clientOptions) {
+		o.batchedReads = true
+	}
+}
@@ -0,0 +1,55 @@
+package proto
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minPooledClass and maxPooledClass bound the buffer sizes kept in framePools, from 512B to
+	// 64KiB. Larger frames are rare and are allocated as needed.
+	minPooledClass = 9
+	maxPooledClass = 16
+)
+
+// framePools holds frame buffers by size class, each class holding buffers of a power of two
+// size
+var framePools [maxPooledClass - minPooledClass + 1]sync.Pool
+
+// sizeClass returns the index into framePools of the class holding buffers of at least n bytes.
+// It reports false if n is too large to be pooled.
+func sizeClass(n int) (int, bool) {
+	class := max(bits.Len(uint(max(n, 1)-1)), minPooledClass)
+	if class > maxPooledClass {
+		return 0, false
+	}
+
+	return class - minPooledClass, true
+}
+
+// getBuffer returns a buffer of n bytes, taken from the pool if n is small enough to be pooled.
+func getBuffer(n int) *[]byte {
+	class, ok := sizeClass(n)
+	if !ok {
+		buf := make([]byte, n)
+		return &buf
+	}
+
+	if buf, ok := framePools[class].Get().(*[]byte); ok {
+		*buf = (*buf)[:n]
+		return buf
+	}
+
+	buf := make([]byte, n, 1<<(class+minPooledClass))
+	return &buf
+}
+
+// putBuffer returns a buffer from getBuffer to its pool.
+func putBuffer(buf *[]byte) {
+	class, ok := sizeClass(cap(*buf))
+	if !ok || cap(*buf) != 1<<(class+minPooledClass) {
+		return
+	}
+
+	framePools[class].Put(buf)
+}
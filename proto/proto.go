@@ -2,13 +2,21 @@ package proto
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cedws/w101-client-go/login"
 	"github.com/cedws/w101-client-go/proto/control"
+	"golang.org/x/time/rate"
 )
 
 const heartbeatInterval = 10 * time.Second
@@ -26,6 +34,14 @@ type Message interface {
 	MessageUnmarshaler
 }
 
+// RoutableMessage is a message that knows the service and order it's sent with. Generated
+// messages implement it.
+type RoutableMessage interface {
+	Message
+	Service() byte
+	Order() byte
+}
+
 type DMLMessage struct {
 	ServiceID   byte
 	OrderNumber byte
@@ -34,15 +50,24 @@ type DMLMessage struct {
 
 func (d *DMLMessage) Unmarshal(buf []byte) error {
 	if len(buf) < 4 {
-		return fmt.Errorf("invalid dml message")
+		return &DecodeError{
+			Raw:    buf,
+			Offset: len(buf),
+			Cause:  fmt.Errorf("invalid dml message, expected at least 4 bytes but got %v", len(buf)),
+		}
 	}
 
 	d.ServiceID = buf[0]
 	d.OrderNumber = buf[1]
 
-	dataLen := binary.LittleEndian.Uint16(buf[2:4]) + 1
-	if dataLen > uint16(len(buf)) {
-		return fmt.Errorf("invalid dml message")
+	// The declared length doesn't count the frame terminator that follows the message
+	dataLen := int(binary.LittleEndian.Uint16(buf[2:4])) + 1
+	if dataLen < 4 || dataLen > len(buf) {
+		return &DecodeError{
+			Raw:    buf,
+			Offset: 2,
+			Cause:  fmt.Errorf("invalid dml message, length %v doesn't fit %v bytes", dataLen, len(buf)),
+		}
 	}
 	d.Packet = buf[4:dataLen]
 
@@ -65,97 +90,518 @@ func (d DMLMessage) Marshal() []byte {
 type Client struct {
 	router *MessageRouter
 
-	conn    net.Conn
-	frameRW frameReadWriter
+	// dial opens a new connection to the server, it's used to reconnect
+	dial func(ctx context.Context) (net.Conn, error)
+
+	connMu sync.Mutex
+	conn   *connection
 
 	readControlCh  chan *Frame
 	readMessageCh  chan *Frame
 	writeMessageCh chan *Frame
 
-	session          Session
-	sessionHeartbeat *time.Ticker
-	connected        bool
+	opts clientOptions
+
+	state atomic.Int32
+
+	sessionMu sync.RWMutex
+	session   Session
+
+	heartbeatMu         sync.Mutex
+	lastHeartbeat       time.Time
+	lastServerKeepAlive time.Time
+	// keepAliveSent is when the unanswered keepalive was sent, it's zero once answered
+	keepAliveSent    time.Time
+	keepAliveLatency time.Duration
+
+	pendingMu sync.Mutex
+	pending   pendingRequests
+
+	// sendMu is held for reading while queueing a frame so that Shutdown can wait for queued
+	// writes to finish before stopping them
+	sendMu        sync.RWMutex
+	writesStopped bool
+	drained       chan struct{}
 
+	// reconnectConn is the new connection while OnReconnect runs, frames sent then are written
+	// to it directly so that they go out before anything queued during the outage
+	reconnectMu   sync.Mutex
+	reconnectConn *connection
+
+	// done is closed when the client is closed, goroutines select on it rather than the frame
+	// channels being closed so that late sends can't panic
+	done      chan struct{}
+	closeOnce sync.Once
+
+	errMu sync.Mutex
+	err   error
+
+	stats clientStats
+
+	// limiter throttles written message frames, it's nil without WithRateLimit
+	limiter *rate.Limiter
+}
+
+// connection holds the state of a single connection to the server. A client has one connection
+// at a time, but may replace it when reconnecting.
+type connection struct {
+	conn    net.Conn
+	frameRW frameReadWriter
+
+	// done is closed when the connection dies or the client is closed
+	done      chan struct{}
 	closeOnce sync.Once
+	closeErr  error
+
+	// err is the read or write error that killed the connection, it's only set once done is closed
+	err error
 }
 
-func Dial(ctx context.Context, remote string, router *MessageRouter) (*Client, error) {
-	dialer := &net.Dialer{}
-	conn, err := dialer.DialContext(ctx, "tcp", remote)
+func newConnection(conn net.Conn, opts clientOptions) *connection {
+	return &connection{
+		conn: conn,
+		frameRW: frameReadWriter{
+			FrameReader{Reader: conn, Magic: opts.frameMagic, MaxFrameSize: opts.maxFrameSize},
+			FrameWriter{writer: conn, Magic: opts.frameMagic},
+		},
+		done: make(chan struct{}),
+	}
+}
+
+func (cn *connection) close() error {
+	return cn.fail(nil)
+}
+
+// fail closes the connection, recording err as the reason if it's the first to close it.
+func (cn *connection) fail(err error) error {
+	cn.closeOnce.Do(func() {
+		cn.err = err
+		close(cn.done)
+		cn.closeErr = cn.conn.Close()
+	})
+
+	return cn.closeErr
+}
+
+// Dial connects to the server at remote and negotiates a session. The client runs until it's
+// closed or ctx is cancelled.
+func Dial(ctx context.Context, remote string, router *MessageRouter, opts ...Option) (*Client, error) {
+	client, err := newClient(router, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	frameRW := frameReadWriter{
-		FrameReader{conn},
-		FrameWriter{conn},
+	client.dial = func(ctx context.Context) (net.Conn, error) {
+		return client.dialRemote(ctx, remote)
+	}
+
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.run(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (c *Client) dialRemote(ctx context.Context, remote string) (net.Conn, error) {
+	conn, err := c.opts.dialer.DialContext(ctx, "tcp", remote)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.opts.tlsConfig == nil {
+		return conn, nil
+	}
+
+	config := c.opts.tlsConfig
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(remote)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake failed: %w", err)
+	}
+
+	return tlsConn, nil
+}
+
+// NewClient negotiates a session over an existing connection, which the client takes ownership
+// of. The client can't redial the server, so WithReconnect has no effect and the client shuts
+// down when conn fails. The client runs until it's closed or ctx is cancelled.
+func NewClient(ctx context.Context, conn net.Conn, router *MessageRouter, opts ...Option) (*Client, error) {
+	client, err := newClient(router, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := client.run(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func newClient(router *MessageRouter, opts []Option) (*Client, error) {
+	options := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.dialer == nil {
+		options.dialer = &net.Dialer{}
+	}
+
+	if options.heartbeatInterval <= 0 {
+		return nil, fmt.Errorf("proto: invalid heartbeat interval %v", options.heartbeatInterval)
+	}
+	if options.rateLimit != 0 && options.rateLimit != rate.Inf && options.rateBurst <= 0 {
+		return nil, fmt.Errorf("proto: invalid rate limit burst %v", options.rateBurst)
+	}
+	if options.channelBuffer < 0 {
+		return nil, fmt.Errorf("proto: invalid channel buffer size %v", options.channelBuffer)
+	}
+	if options.livenessMiss != 0 {
+		if options.livenessMiss < 2 {
+			return nil, fmt.Errorf("proto: invalid liveness check of %v missed heartbeats", options.livenessMiss)
+		}
+		if options.readTimeout != 0 {
+			return nil, fmt.Errorf("proto: read timeout and liveness check can't both be set")
+		}
+		options.readTimeout = time.Duration(options.livenessMiss) * options.heartbeatInterval
+	}
+	if options.heartbeat && options.readTimeout > 0 && options.readTimeout <= options.heartbeatInterval {
+		return nil, fmt.Errorf("proto: read timeout %v must be longer than heartbeat interval %v", options.readTimeout, options.heartbeatInterval)
 	}
 
 	client := &Client{
 		router: router,
 
-		conn:    conn,
-		frameRW: frameRW,
+		readControlCh:  make(chan *Frame, options.channelBuffer),
+		readMessageCh:  make(chan *Frame, options.channelBuffer),
+		writeMessageCh: make(chan *Frame, options.channelBuffer),
+
+		opts: options,
+
+		done:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+
+	if options.rateLimit != 0 {
+		client.limiter = rate.NewLimiter(options.rateLimit, options.rateBurst)
+	}
+
+	client.state.Store(int32(StateConnecting))
+
+	return client, nil
+}
+
+// run negotiates a session on conn and starts the client's goroutines. The client is shut down
+// when ctx is cancelled.
+func (c *Client) run(ctx context.Context, conn net.Conn) error {
+	cn, err := c.establish(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	c.attach(cn)
+	c.start(cn)
+
+	go c.handleControl()
+	go c.handleMessages()
+	go c.supervise(cn)
+	go c.watchContext(ctx)
 
-		readControlCh:  make(chan *Frame, 8),
-		readMessageCh:  make(chan *Frame, 8),
-		writeMessageCh: make(chan *Frame, 8),
+	return nil
+}
+
+// watchContext shuts the client down with ctx's error once ctx is cancelled. Every goroutine
+// selects on the client's done channel, so they all exit.
+func (c *Client) watchContext(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		c.shutdown(ctx.Err())
+	case <-c.done:
+	}
+}
 
-		sessionHeartbeat: time.NewTicker(heartbeatInterval),
+// connect dials the server and negotiates a session on the new connection.
+func (c *Client) connect(ctx context.Context) (*connection, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	go client.read()
-	go client.write()
+	return c.establish(ctx, conn)
+}
+
+// establish negotiates a session on conn, closing it if the handshake fails.
+func (c *Client) establish(ctx context.Context, conn net.Conn) (*connection, error) {
+	cn := newConnection(conn, c.opts)
 
-	if err := client.handshake(ctx); err != nil {
+	span := c.traceHandshake(ctx, cn)
+	err := c.handshake(ctx, cn)
+	span.End(err)
+
+	if err != nil {
+		cn.close()
 		return nil, fmt.Errorf("session handshake failed: %w", err)
 	}
 
-	go client.handleControl()
-	go client.handleMessages()
+	return cn, nil
+}
+
+// attach makes cn the client's connection. It closes cn and reports false if the client has been
+// closed.
+func (c *Client) attach(cn *connection) bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	select {
+	case <-c.done:
+		cn.close()
+		return false
+	default:
+	}
+
+	c.conn = cn
+	c.state.Store(int32(StateConnected))
 
-	return client, nil
+	return true
+}
+
+// start starts the goroutines of cn, which must have been attached.
+func (c *Client) start(cn *connection) {
+	if c.opts.heartbeat {
+		c.heartbeatMu.Lock()
+		c.lastHeartbeat = c.opts.now()
+		c.heartbeatMu.Unlock()
+
+		go c.heartbeat(cn)
+	}
+
+	go c.read(cn)
+	go c.write(cn)
 }
 
-func (c *Client) handshake(ctx context.Context) error {
+// supervise waits for cn to die and then either reconnects or closes the client.
+func (c *Client) supervise(cn *connection) {
 	for {
 		select {
-		case frame, ok := <-c.readControlCh:
-			if !ok {
-				return fmt.Errorf("connection closed before handshake")
-			}
+		case <-cn.done:
+		case <-c.done:
+			return
+		}
 
-			c.handleControlFrame(frame)
+		if c.opts.backoff == nil || c.dial == nil {
+			c.shutdown(cn.err)
+			return
+		}
+
+		next, err := c.reconnect(cn.err)
+		if next == nil {
+			c.shutdown(err)
+			return
+		}
 
-			if c.connected {
-				go c.heartbeat()
-				return nil
+		cn = next
+	}
+}
+
+// reconnect redials the server until a session is negotiated, the backoff gives up or the
+// client is closed. If it doesn't reconnect it returns the error from the last attempt, starting
+// with cause.
+func (c *Client) reconnect(cause error) (*connection, error) {
+	c.state.Store(int32(StateReconnecting))
+
+	err := cause
+	for attempt := 1; ; attempt++ {
+		delay := c.opts.backoff(attempt)
+		if delay < 0 {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return nil, err
+		}
+
+		// Abandon the attempt if the client is closed while dialing
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
 			}
-		case <-ctx.Done():
-			return ctx.Err()
+		}()
+
+		var cn *connection
+		cn, err = c.connect(ctx)
+		cancel()
+
+		if err != nil {
+			continue
+		}
+
+		if !c.attach(cn) {
+			return nil, err
+		}
+
+		if c.opts.onReconnect != nil {
+			c.reconnectMu.Lock()
+			c.reconnectConn = cn
+			c.reconnectMu.Unlock()
+
+			c.opts.onReconnect(c)
+
+			c.reconnectMu.Lock()
+			c.reconnectConn = nil
+			c.reconnectMu.Unlock()
 		}
+
+		c.start(cn)
+		return cn, nil
+	}
+}
+
+// handshake waits for the server to offer a session on cn and accepts it. Frames are read and
+// written directly as the connection's goroutines haven't been started yet.
+func (c *Client) handshake(ctx context.Context, cn *connection) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.negotiate(cn)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		cn.close()
+		<-errCh
+		return ctx.Err()
 	}
 }
 
-func (c *Client) heartbeat() {
-	for range c.sessionHeartbeat.C {
-		keepAlive := &control.ClientKeepAlive{
-			SessionID:           c.session.ID,
-			TimeMillis:          uint16(time.Now().Nanosecond() / 1_000_000),
-			SessionDurationMins: uint16(time.Since(c.session.Start).Minutes()),
+func (c *Client) negotiate(cn *connection) error {
+	for {
+		frame, err := cn.frameRW.Read()
+		if err != nil {
+			return fmt.Errorf("connection closed before handshake: %w", err)
 		}
+		c.stats.recordRead(frame)
+		if c.opts.onRawFrame != nil {
+			c.opts.onRawFrame(frame)
+		}
+		if !frame.Control {
+			continue
+		}
+
+		switch frame.Opcode {
+		case control.PktSessionOffer:
+			accept, err := c.acceptSession(frame)
+			if err != nil {
+				return err
+			}
+
+			if err := cn.frameRW.Write(accept); err != nil {
+				return err
+			}
+			c.stats.recordWritten(accept)
 
-		c.writeMessageCh <- &Frame{
-			Control:     true,
-			Opcode:      control.PktSessionKeepAlive,
-			MessageData: keepAlive.Marshal(),
+			return nil
+		case control.PktSessionKeepAlive:
+			c.recordServerKeepAlive(frame)
+
+			rsp := keepAliveRsp()
+			if err := cn.frameRW.Write(rsp); err != nil {
+				return err
+			}
+			c.stats.recordWritten(rsp)
+		}
+	}
+}
+
+func (c *Client) heartbeat(cn *connection) {
+	ticker := time.NewTicker(c.opts.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendHeartbeat()
+		case <-cn.done:
+			return
+		case <-c.done:
+			return
 		}
 	}
 }
 
+func (c *Client) sendHeartbeat() {
+	now := c.opts.now()
+	session := c.currentSession()
+
+	millis, mins := session.keepAliveTimes(now)
+	keepAlive := &control.ClientKeepAlive{
+		SessionID:           session.ID,
+		TimeMillis:          millis,
+		SessionDurationMins: mins,
+	}
+
+	// Record the send first so that a fast response can't arrive before it
+	c.heartbeatMu.Lock()
+	c.lastHeartbeat = now
+	c.keepAliveSent = now
+	c.heartbeatMu.Unlock()
+
+	c.send(&Frame{
+		Control:     true,
+		Opcode:      control.PktSessionKeepAlive,
+		MessageData: keepAlive.Marshal(),
+	})
+}
+
+// NextHeartbeat returns when the client is next due to send a keepalive. It returns the zero
+// time if heartbeats are disabled.
+func (c *Client) NextHeartbeat() time.Time {
+	if !c.opts.heartbeat {
+		return time.Time{}
+	}
+
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+
+	return c.lastHeartbeat.Add(c.opts.heartbeatInterval)
+}
+
+// State returns the current state of the client's connection.
+func (c *Client) State() ConnState {
+	return ConnState(c.state.Load())
+}
+
 func (c *Client) handleControl() {
-	for frame := range c.readControlCh {
-		c.handleControlFrame(frame)
+	for {
+		select {
+		case frame := <-c.readControlCh:
+			c.handleControlFrame(frame)
+		case <-c.done:
+			return
+		}
 	}
 }
 
@@ -165,37 +611,177 @@ func (c *Client) handleControlFrame(frame *Frame) {
 		c.handleSessionKeepAlive(frame)
 	case control.PktSessionOffer:
 		c.handleSessionOffer(frame)
+	case control.PktSessionKeepAliveRsp:
+		c.handleKeepAliveRsp()
 	case control.PktSessionAccept:
 		// ignore
+	default:
+		if c.opts.onUnhandled != nil {
+			c.opts.onUnhandled(frame)
+		}
 	}
 }
 
 func (c *Client) handleMessages() {
-	for frame := range c.readMessageCh {
-		var dmlMessage DMLMessage
-		if err := dmlMessage.Unmarshal(frame.MessageData); err != nil {
+	dispatch := c.routeMessage
+	if c.opts.handlerConcurrency > 1 {
+		dispatch = c.startHandlerWorkers(c.opts.handlerConcurrency)
+	}
+
+	for {
+		var frame *Frame
+		select {
+		case frame = <-c.readMessageCh:
+		case <-c.done:
 			return
 		}
 
-		if err := c.router.Handle(dmlMessage.ServiceID, dmlMessage.OrderNumber, dmlMessage); err != nil {
-			c.Close()
+		msgs, err := decodeMessages(frame.MessageData, c.opts.batchedReads)
+		if err != nil {
+			c.stats.unmarshalErrors.Add(1)
+			if c.opts.onDecodeError != nil {
+				c.opts.onDecodeError(frame, err)
+				continue
+			}
+
+			c.shutdown(err)
 			return
 		}
+
+		for _, msg := range msgs {
+			c.stats.messagesRouted.Add(1)
+			if !dispatch(frame, msg) {
+				return
+			}
+		}
+	}
+}
+
+// routeMessage passes a message to any Request waiting on it and then to its handlers. It
+// reports false if a handler failed and the client has been shut down.
+func (c *Client) routeMessage(frame *Frame, d DMLMessage) bool {
+	c.pendingMu.Lock()
+	delivered := c.pending.deliver(d)
+	c.pendingMu.Unlock()
+
+	if !delivered && c.opts.onUnhandled != nil && !c.router.hasHandlers(d.ServiceID, d.OrderNumber) {
+		c.opts.onUnhandled(frame)
+	}
+
+	span := c.traceHandle(d)
+	err := c.router.Handle(d.ServiceID, d.OrderNumber, d)
+	span.End(err)
+
+	if err != nil {
+		handlerErr := &HandlerError{
+			Service: d.ServiceID,
+			Order:   d.OrderNumber,
+			Err:     err,
+		}
+
+		// Panics are reported but don't stop the client
+		if onlyPanics(err) {
+			if c.opts.onPanic != nil {
+				c.opts.onPanic(handlerErr)
+			}
+			return true
+		}
+
+		var decodeErr *DecodeError
+		if c.opts.onDecodeError != nil && errors.As(err, &decodeErr) {
+			c.stats.unmarshalErrors.Add(1)
+			c.opts.onDecodeError(frame, handlerErr)
+			return true
+		}
+
+		c.shutdown(handlerErr)
+		return false
 	}
+
+	return true
 }
 
-func (c *Client) handleSessionKeepAlive(_ *Frame) {
-	c.writeMessageCh <- &Frame{
+func keepAliveRsp() *Frame {
+	return &Frame{
 		Control:     true,
 		Opcode:      control.PktSessionKeepAliveRsp,
 		MessageData: (&control.KeepAliveRsp{}).Marshal(),
 	}
 }
 
+func (c *Client) handleSessionKeepAlive(frame *Frame) {
+	c.recordServerKeepAlive(frame)
+	c.send(keepAliveRsp())
+}
+
+func (c *Client) recordServerKeepAlive(frame *Frame) {
+	keepAlive := &control.ServerKeepAlive{}
+	if err := keepAlive.Unmarshal(frame.MessageData); err != nil {
+		return
+	}
+
+	c.heartbeatMu.Lock()
+	c.lastServerKeepAlive = c.opts.now()
+	c.heartbeatMu.Unlock()
+}
+
+// handleKeepAliveRsp measures the round trip of the keepalive being answered. The response has
+// no payload, so it's matched to the most recent keepalive the client sent.
+func (c *Client) handleKeepAliveRsp() {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+
+	if c.keepAliveSent.IsZero() {
+		return
+	}
+
+	c.keepAliveLatency = c.opts.now().Sub(c.keepAliveSent)
+	c.keepAliveSent = time.Time{}
+}
+
+// KeepAliveLatency returns the round trip time of the last keepalive the server answered. It
+// returns zero if no keepalive has been answered yet.
+func (c *Client) KeepAliveLatency() time.Duration {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+
+	return c.keepAliveLatency
+}
+
+// LastServerKeepAlive returns when the client last received a keepalive from the server. It
+// returns the zero time if the server hasn't sent one.
+func (c *Client) LastServerKeepAlive() time.Time {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+
+	return c.lastServerKeepAlive
+}
+
 func (c *Client) handleSessionOffer(frame *Frame) {
+	accept, err := c.acceptSession(frame)
+	if err != nil {
+		// An offer that fails verification may have been forged, so the connection can't be
+		// trusted. Other bad offers are ignored.
+		if errors.Is(err, control.ErrInvalidSignature) {
+			c.shutdown(err)
+		}
+		return
+	}
+
+	c.send(accept)
+}
+
+// acceptSession records the session offered in frame and returns the frame accepting it.
+func (c *Client) acceptSession(frame *Frame) (*Frame, error) {
 	offer := &control.SessionOffer{}
 	if err := offer.Unmarshal(frame.MessageData); err != nil {
-		return
+		return nil, err
+	}
+
+	if c.opts.serverKey != nil {
+		if err := offer.Verify(c.opts.serverKey); err != nil {
+			return nil, err
+		}
 	}
 
 	accept := &control.SessionAccept{
@@ -204,60 +790,219 @@ func (c *Client) handleSessionOffer(frame *Frame) {
 		SessionID:  offer.SessionID,
 	}
 
-	c.writeMessageCh <- &Frame{
-		Control:     true,
-		Opcode:      control.PktSessionAccept,
-		MessageData: accept.Marshal(),
+	if creds := c.opts.credentials; creds != nil {
+		session := login.Session{
+			Username: creds.username,
+			Password: creds.password,
+			SessionParams: login.SessionParams{
+				SessionID:  offer.SessionID,
+				TimeSecs:   offer.TimeSecs,
+				TimeMillis: offer.TimeMillis,
+			},
+		}
+		accept.EncryptedMessage = session.Rec1()
 	}
 
-	c.connected = true
+	c.sessionMu.Lock()
 	c.session = Session{
 		ID:         offer.SessionID,
 		TimeSecs:   offer.TimeSecs,
 		TimeMillis: offer.TimeMillis,
-		Start:      time.Now(),
+		Start:      c.opts.now(),
 	}
+	c.sessionMu.Unlock()
+
+	return &Frame{
+		Control:     true,
+		Opcode:      control.PktSessionAccept,
+		MessageData: accept.Marshal(),
+	}, nil
 }
 
-func (c *Client) read() {
-	defer c.Close()
+func (c *Client) read(cn *connection) {
+	defer cn.close()
 
 	for {
-		frame, err := c.frameRW.Read()
+		if c.opts.readTimeout > 0 {
+			cn.conn.SetReadDeadline(time.Now().Add(c.opts.readTimeout))
+		}
+
+		frame, err := cn.frameRW.Read()
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = fmt.Errorf("%w: %w", ErrReadTimeout, err)
+				if c.opts.livenessMiss > 0 {
+					err = fmt.Errorf("%w after %v missed heartbeats: %w", ErrConnectionDead, c.opts.livenessMiss, err)
+				}
+			}
+
+			cn.fail(err)
 			return
 		}
 
+		c.stats.recordRead(frame)
+
+		if c.opts.onRawFrame != nil {
+			c.opts.onRawFrame(frame)
+		}
+
+		ch := c.readMessageCh
 		if frame.Control {
-			c.readControlCh <- frame
-		} else {
-			c.readMessageCh <- frame
+			ch = c.readControlCh
+		}
+
+		select {
+		case ch <- frame:
+		case <-cn.done:
+			return
+		case <-c.done:
+			return
 		}
 	}
 }
 
-func (c *Client) write() {
-	defer c.Close()
+func (c *Client) write(cn *connection) {
+	defer cn.close()
+
+	for {
+		select {
+		case frame := <-c.writeMessageCh:
+			if frame == drainMarker {
+				close(c.drained)
+				continue
+			}
 
-	for frame := range c.writeMessageCh {
-		if err := c.frameRW.Write(frame); err != nil {
+			if err := c.writeFrame(cn, frame); err != nil {
+				return
+			}
+		case <-cn.done:
+			return
+		case <-c.done:
 			return
 		}
 	}
 }
 
+// writeFrame writes frame to cn, failing cn if the write fails.
+func (c *Client) writeFrame(cn *connection, frame *Frame) error {
+	if c.opts.writeTimeout > 0 {
+		cn.conn.SetWriteDeadline(time.Now().Add(c.opts.writeTimeout))
+	}
+
+	span := c.traceWrite(frame)
+	err := cn.frameRW.Write(frame)
+	span.End(err)
+
+	if err != nil {
+		cn.fail(err)
+		return err
+	}
+
+	c.stats.recordWritten(frame)
+	return nil
+}
+
+// waitRateLimit waits until the rate limiter allows a message frame to be queued. Waiting happens
+// in the caller rather than the writer so that control frames never queue behind a throttled
+// message. It reports false if the client was closed while waiting.
+func (c *Client) waitRateLimit(frame *Frame) bool {
+	if c.limiter == nil || frame.Control {
+		return true
+	}
+
+	reservation := c.limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+	}
+
+	reservation.Cancel()
+	return false
+}
+
+// send queues a frame to be written. It returns ErrClientClosed without queueing the frame if
+// the client has been closed or is shutting down.
+func (c *Client) send(frame *Frame) error {
+	if !c.waitRateLimit(frame) {
+		return ErrClientClosed
+	}
+
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if c.writesStopped {
+		return ErrClientClosed
+	}
+
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	// The connection's writer isn't running while OnReconnect runs
+	c.reconnectMu.Lock()
+	if cn := c.reconnectConn; cn != nil {
+		defer c.reconnectMu.Unlock()
+		return c.writeFrame(cn, frame)
+	}
+	c.reconnectMu.Unlock()
+
+	select {
+	case c.writeMessageCh <- frame:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+func (c *Client) currentSession() Session {
+	c.sessionMu.RLock()
+	defer c.sessionMu.RUnlock()
+
+	return c.session
+}
+
 func (c *Client) SessionID() uint16 {
-	return c.session.ID
+	return c.currentSession().ID
 }
 
 func (c *Client) SessionTimeSecs() uint32 {
-	return c.session.TimeSecs
+	return c.currentSession().TimeSecs
 }
 
 func (c *Client) SessionTimeMillis() uint32 {
-	return c.session.TimeMillis
+	return c.currentSession().TimeMillis
+}
+
+// SessionAge returns how long ago the current session was started. It starts again from zero
+// when the client reconnects.
+func (c *Client) SessionAge() time.Duration {
+	return max(c.opts.now().Sub(c.currentSession().Start), 0)
+}
+
+// LoginInputs returns the negotiated session parameters needed by the login package
+func (c *Client) LoginInputs() login.SessionParams {
+	session := c.currentSession()
+
+	return login.SessionParams{
+		SessionID:  session.ID,
+		TimeSecs:   session.TimeSecs,
+		TimeMillis: session.TimeMillis,
+	}
 }
 
+// WriteMessage queues a message to be written. It returns ErrClientClosed if the client has been
+// closed.
 func (c *Client) WriteMessage(service, order byte, msg Message) error {
 	dml := DMLMessage{
 		ServiceID:   service,
@@ -265,61 +1010,203 @@ func (c *Client) WriteMessage(service, order byte, msg Message) error {
 		Packet:      msg.Marshal(),
 	}
 
-	c.writeMessageCh <- &Frame{
+	return c.send(&Frame{
 		MessageData: dml.Marshal(),
-	}
+	})
+}
 
-	return nil
+// Send queues a message to be written with the service and order it reports. It returns
+// ErrClientClosed if the client has been closed.
+func (c *Client) Send(msg RoutableMessage) error {
+	return c.WriteMessage(msg.Service(), msg.Order(), msg)
+}
+
+// WriteRawFrame queues a caller-built frame to be written as-is. This bypasses DML framing
+// entirely, so the caller is responsible for the frame being valid. It returns ErrClientClosed if
+// the client has been closed.
+func (c *Client) WriteRawFrame(frame *Frame) error {
+	return c.send(frame)
+}
+
+// Done returns a channel that's closed when the client shuts down.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that caused the client to shut down. It returns nil if the client is
+// still running or was shut down by Close.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	return c.err
 }
 
 func (c *Client) Close() error {
+	return c.shutdown(nil)
+}
+
+// shutdown closes the client, recording err as the reason if it's the first to close it.
+func (c *Client) shutdown(err error) error {
 	c.closeOnce.Do(func() {
-		c.sessionHeartbeat.Stop()
-		close(c.readControlCh)
-		close(c.readMessageCh)
-		close(c.writeMessageCh)
+		c.errMu.Lock()
+		c.err = err
+		c.errMu.Unlock()
+
+		close(c.done)
 	})
-	return c.conn.Close()
+
+	c.connMu.Lock()
+	c.state.Store(int32(StateClosed))
+	cn := c.conn
+	c.connMu.Unlock()
+
+	if cn == nil {
+		return nil
+	}
+	return cn.close()
 }
 
-type messageRouter [255][]func(DMLMessage) error
+type routeHandler struct {
+	id     uint64
+	handle func(DMLMessage) error
+}
+
+type messageRouter [255][]routeHandler
 
 type serviceRouter [255]messageRouter
 
+// MessageRouter dispatches messages to the handlers registered for them. Handlers and middleware
+// can be registered at any time, including while a client is routing messages. A MessageRouter
+// holds a lock, so it must not be copied after first use; pass it around by pointer.
 type MessageRouter struct {
-	middleware    []func(any)
+	mu            sync.RWMutex
+	middleware    []func(any) error
 	serviceRoutes serviceRouter
+	nextID        uint64
+}
+
+// HandlerID identifies a registered message handler so that it can be unregistered.
+type HandlerID struct {
+	service, order byte
+	id             uint64
 }
 
+// NewMessageRouter returns an empty router. It's returned by value for compatibility, store it in
+// a variable and use its address rather than copying it once handlers are registered.
 func NewMessageRouter() MessageRouter {
 	return MessageRouter{}
 }
 
+// Handle passes d to every handler registered for service and order. It stops at the first
+// handler to return an error. A handler that panics is skipped and the panic is returned as a
+// PanicError once the remaining handlers have run.
 func (r *MessageRouter) Handle(service, order byte, d DMLMessage) error {
-	for _, handler := range r.serviceRoutes[service][order] {
-		if err := handler(d); err != nil {
-			return err
+	// Handlers are called without the lock held so that they can register more handlers
+	r.mu.RLock()
+	handlers := r.serviceRoutes[service][order]
+	r.mu.RUnlock()
+
+	var panics []error
+	for _, handler := range handlers {
+		if err := handler.call(d); err != nil {
+			if _, ok := err.(*PanicError); ok {
+				panics = append(panics, err)
+				continue
+			}
+
+			if len(panics) == 0 {
+				return err
+			}
+			return errors.Join(append(panics, err)...)
 		}
 	}
 
-	return nil
+	return errors.Join(panics...)
+}
+
+// call runs the handler, converting a panic in it or its middleware into a PanicError.
+func (h routeHandler) call(d DMLMessage) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+
+	return h.handle(d)
 }
 
 // RegisterMiddleware registers a middleware function that will be called for every message
 // that matches type T. Middleware can receive every message by registering middleware that
 // receives the *any* type.
 func RegisterMiddleware[T any](router *MessageRouter, handler func(T)) {
-	handleFunc := func(msg any) {
+	RegisterMiddlewareE(router, func(msg T) error {
+		handler(msg)
+		return nil
+	})
+}
+
+// RegisterMiddlewareE is like RegisterMiddleware, but the middleware can return an error. An error
+// stops the message from reaching its handlers and shuts the client down.
+func RegisterMiddlewareE[T any](router *MessageRouter, handler func(T) error) {
+	handleFunc := func(msg any) error {
 		msgType, ok := msg.(T)
 		if ok {
-			handler(msgType)
+			return handler(msgType)
 		}
+		return nil
 	}
 
+	router.mu.Lock()
 	router.middleware = append(router.middleware, handleFunc)
+	router.mu.Unlock()
+}
+
+func (r *MessageRouter) hasHandlers(service, order byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.serviceRoutes[service][order]) > 0
+}
+
+// Unregister removes a handler registered with one of the RegisterMessageHandler functions. It
+// does nothing if the handler has already been removed.
+func (r *MessageRouter) Unregister(id HandlerID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Handle may be iterating over the current slice, so don't modify it in place
+	handlers := slices.Clone(r.serviceRoutes[id.service][id.order])
+	r.serviceRoutes[id.service][id.order] = slices.DeleteFunc(handlers, func(h routeHandler) bool {
+		return h.id == id.id
+	})
+}
+
+func RegisterMessageHandler[T any](router *MessageRouter, service, order byte, handler func(T)) HandlerID {
+	return registerHandler(router, service, order, func(msg T, _ DMLMessage) error {
+		handler(msg)
+		return nil
+	})
+}
+
+// RegisterMessageHandlerE is like RegisterMessageHandler, but the handler can return an error.
+// An error shuts the client down, and is available from Client.Err wrapped in a HandlerError.
+func RegisterMessageHandlerE[T any](router *MessageRouter, service, order byte, handler func(T) error) HandlerID {
+	return registerHandler(router, service, order, func(msg T, _ DMLMessage) error {
+		return handler(msg)
+	})
 }
 
-func RegisterMessageHandler[T any](router *MessageRouter, service, order byte, handler func(T)) {
+// RegisterMessageHandlerRaw is like RegisterMessageHandler, but the handler also receives the
+// DMLMessage that the message was decoded from.
+func RegisterMessageHandlerRaw[T any](router *MessageRouter, service, order byte, handler func(T, DMLMessage)) HandlerID {
+	return registerHandler(router, service, order, func(msg T, d DMLMessage) error {
+		handler(msg, d)
+		return nil
+	})
+}
+
+func registerHandler[T any](router *MessageRouter, service, order byte, handler func(T, DMLMessage) error) HandlerID {
 	decodeFunc := func(d DMLMessage) error {
 		var msg T
 
@@ -330,17 +1217,32 @@ func RegisterMessageHandler[T any](router *MessageRouter, service, order byte, h
 		}
 
 		if err := dec.Unmarshal(d.Packet); err != nil {
-			return err
+			return &DecodeError{Raw: d.Packet, Cause: err}
 		}
 
-		for _, middleware := range router.middleware {
-			middleware(msg)
-		}
+		router.mu.RLock()
+		middlewares := router.middleware
+		router.mu.RUnlock()
 
-		handler(msg)
+		for _, middleware := range middlewares {
+			if err := middleware(msg); err != nil {
+				return err
+			}
+		}
 
-		return nil
+		return handler(msg, d)
 	}
 
-	router.serviceRoutes[service][order] = append(router.serviceRoutes[service][order], decodeFunc)
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.nextID++
+	id := HandlerID{service: service, order: order, id: router.nextID}
+
+	router.serviceRoutes[service][order] = append(router.serviceRoutes[service][order], routeHandler{
+		id:     id.id,
+		handle: decodeFunc,
+	})
+
+	return id
 }
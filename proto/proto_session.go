@@ -8,3 +8,15 @@ type Session struct {
 	TimeMillis uint32
 	Start      time.Time
 }
+
+// keepAliveTimes returns the time fields of a client keepalive sent at now. The milliseconds are
+// the session clock, which starts at the time agreed in the offer and advances with the time
+// elapsed since the session started, wrapped to 16 bits. The minutes are the whole minutes
+// elapsed since the session started.
+func (s Session) keepAliveTimes(now time.Time) (millis, mins uint16) {
+	elapsed := max(now.Sub(s.Start), 0)
+
+	clock := uint64(s.TimeSecs)*1000 + uint64(s.TimeMillis) + uint64(elapsed.Milliseconds())
+
+	return uint16(clock), uint16(elapsed / time.Minute)
+}
@@ -0,0 +1,1258 @@
+package proto
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cedws/w101-client-go/login"
+	"github.com/cedws/w101-client-go/proto/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"golang.org/x/time/rate"
+)
+
+var testSession = control.SessionOffer{
+	SessionID:  3258,
+	TimeSecs:   1617815695,
+	TimeMillis: 805,
+}
+
+type testServer struct {
+	addr   string
+	conns  chan net.Conn
+	frames chan *Frame
+}
+
+// startTestServer offers a session to every connection it accepts and then forwards every frame
+// the client writes.
+func startTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	return serveTestServer(t, listener)
+}
+
+func serveTestServer(t *testing.T, listener net.Listener) *testServer {
+	t.Cleanup(func() { listener.Close() })
+
+	srv := &testServer{
+		addr:   listener.Addr().String(),
+		conns:  make(chan net.Conn, 8),
+		frames: make(chan *Frame, 64),
+	}
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(srv.frames)
+		}()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.serve(conn)
+			}()
+		}
+	}()
+
+	return srv
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	writer := FrameWriter{writer: conn}
+	offer := testSession
+	if err := writer.Write(&Frame{
+		Control:     true,
+		Opcode:      control.PktSessionOffer,
+		MessageData: offer.Marshal(),
+	}); err != nil {
+		return
+	}
+
+	s.conns <- conn
+
+	reader := FrameReader{Reader: conn}
+	for {
+		frame, err := reader.Read()
+		if err != nil {
+			return
+		}
+		s.frames <- frame
+	}
+}
+
+func dialTestServer(t *testing.T, srv *testServer, opts ...Option) *Client {
+	// The client shuts down once its context is cancelled, so it lives until the test ends
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	router := NewMessageRouter()
+	client, err := Dial(ctx, srv.addr, &router, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// collectFrames returns every frame the server received within d.
+func (s *testServer) collectFrames(d time.Duration) []*Frame {
+	var frames []*Frame
+
+	timeout := time.After(d)
+	for {
+		select {
+		case frame, ok := <-s.frames:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, frame)
+		case <-timeout:
+			return frames
+		}
+	}
+}
+
+func TestNoHeartbeat(t *testing.T) {
+	srv := startTestServer(t)
+	dialTestServer(t, srv, WithNoHeartbeat(), WithHeartbeatInterval(10*time.Millisecond))
+
+	for _, frame := range srv.collectFrames(200 * time.Millisecond) {
+		assert.NotEqual(t, control.PktSessionKeepAlive, frame.Opcode)
+	}
+}
+
+func TestWriteRawFrame(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	frame := &Frame{
+		Opcode:      0x7F,
+		MessageData: []byte{0xDE, 0xAD, 0xBE, 0xEF},
+	}
+	require.NoError(t, client.WriteRawFrame(frame))
+
+	for _, received := range srv.collectFrames(time.Second) {
+		if received.Opcode == frame.Opcode {
+			assert.Equal(t, frame.Control, received.Control)
+			// FrameReader keeps the trailing terminator byte written by FrameWriter
+			assert.Equal(t, append(frame.MessageData, 0), received.MessageData)
+			return
+		}
+	}
+
+	t.Fatal("raw frame was not received")
+}
+
+func TestDMLMessageUnmarshalDecodeError(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"PastEnd", []byte{0x5, 0x1, 0xFF, 0x00, 0xAA}},
+		{"LengthZero", []byte{0x5, 0x1, 0x00, 0x00, 0xAA}},
+		{"LengthTwo", []byte{0x5, 0x1, 0x02, 0x00, 0xAA}},
+		{"LengthMax", []byte{0x5, 0x1, 0xFF, 0xFF, 0xAA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg DMLMessage
+			err := msg.Unmarshal(tt.raw)
+
+			var decodeErr *DecodeError
+			require.True(t, errors.As(err, &decodeErr))
+			assert.Equal(t, tt.raw, decodeErr.Raw)
+			assert.Equal(t, 2, decodeErr.Offset)
+		})
+	}
+}
+
+func TestNextHeartbeat(t *testing.T) {
+	now := time.Date(2021, time.April, 7, 17, 14, 55, 0, time.UTC)
+
+	opts := defaultClientOptions()
+	opts.now = func() time.Time { return now }
+
+	client := &Client{
+		opts:           opts,
+		writeMessageCh: make(chan *Frame, 8),
+		lastHeartbeat:  now,
+	}
+	assert.Equal(t, now.Add(heartbeatInterval), client.NextHeartbeat())
+
+	for range 3 {
+		now = now.Add(heartbeatInterval)
+		client.sendHeartbeat()
+
+		assert.Equal(t, now.Add(heartbeatInterval), client.NextHeartbeat())
+	}
+}
+
+func TestKeepAliveTimes(t *testing.T) {
+	start := time.Date(2021, time.April, 7, 17, 14, 55, 0, time.UTC)
+	session := Session{
+		ID:         testSession.SessionID,
+		TimeSecs:   testSession.TimeSecs,
+		TimeMillis: testSession.TimeMillis,
+		Start:      start,
+	}
+
+	millis, mins := session.keepAliveTimes(start)
+	assert.Equal(t, uint16(29117), millis)
+	assert.Equal(t, uint16(0), mins)
+
+	millis, mins = session.keepAliveTimes(start.Add(2*time.Minute + 30500*time.Millisecond))
+	assert.Equal(t, uint16(48545), millis)
+	assert.Equal(t, uint16(2), mins)
+
+	now := start.Add(2*time.Minute + 30500*time.Millisecond)
+	opts := defaultClientOptions()
+	opts.now = func() time.Time { return now }
+
+	client := &Client{
+		opts:           opts,
+		writeMessageCh: make(chan *Frame, 1),
+		session:        session,
+		done:           make(chan struct{}),
+	}
+	client.sendHeartbeat()
+
+	keepAlive := &control.ClientKeepAlive{}
+	require.NoError(t, keepAlive.Unmarshal((<-client.writeMessageCh).MessageData))
+	assert.Equal(t, control.ClientKeepAlive{
+		SessionID:           testSession.SessionID,
+		TimeMillis:          48545,
+		SessionDurationMins: 2,
+	}, *keepAlive)
+}
+
+func TestSessionAge(t *testing.T) {
+	start := time.Date(2021, time.April, 7, 17, 14, 55, 0, time.UTC)
+	now := start.Add(90 * time.Second)
+
+	opts := defaultClientOptions()
+	opts.now = func() time.Time { return now }
+
+	client := &Client{
+		opts:    opts,
+		session: Session{Start: start},
+	}
+	assert.Equal(t, 90*time.Second, client.SessionAge())
+}
+
+func TestLoginInputs(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	expected := login.SessionParams{
+		SessionID:  testSession.SessionID,
+		TimeSecs:   testSession.TimeSecs,
+		TimeMillis: testSession.TimeMillis,
+	}
+	assert.Equal(t, expected, client.LoginInputs())
+}
+
+type testMessage struct {
+	Value uint32
+}
+
+func (m *testMessage) Marshal() []byte {
+	return binary.LittleEndian.AppendUint32(nil, m.Value)
+}
+
+func (m *testMessage) Unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("test message too short")
+	}
+	m.Value = binary.LittleEndian.Uint32(data)
+	return nil
+}
+
+func TestRegisterMessageHandlerRaw(t *testing.T) {
+	router := NewMessageRouter()
+
+	var (
+		received testMessage
+		raw      DMLMessage
+	)
+
+	RegisterMessageHandlerRaw(&router, 5, 1, func(msg testMessage, d DMLMessage) {
+		received = msg
+		raw = d
+	})
+
+	sent := &testMessage{Value: 101}
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: sent.Marshal()}
+
+	require.NoError(t, router.Handle(5, 1, dml))
+	assert.Equal(t, *sent, received)
+	assert.Equal(t, dml, raw)
+}
+
+func TestCloseConcurrentWrites(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithHeartbeatInterval(time.Millisecond))
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			for i := range 100 {
+				client.WriteMessage(5, 1, &testMessage{Value: uint32(i)})
+			}
+		}()
+	}
+
+	close(start)
+	client.Close()
+	wg.Wait()
+
+	// Writes after close must not block or panic either
+	assert.True(t, errors.Is(client.WriteMessage(5, 1, &testMessage{Value: 1}), ErrClientClosed))
+	assert.True(t, errors.Is(client.WriteRawFrame(&Frame{Opcode: 0x7F}), ErrClientClosed))
+}
+
+func TestWriteMessageAfterServerClose(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	conn := <-srv.conns
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return errors.Is(client.WriteMessage(5, 1, &testMessage{Value: 1}), ErrClientClosed)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type routableTestMessage struct {
+	testMessage
+}
+
+func (*routableTestMessage) Service() byte { return 5 }
+func (*routableTestMessage) Order() byte   { return 3 }
+
+func TestSend(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	require.NoError(t, client.Send(&routableTestMessage{testMessage{Value: 7}}))
+
+	for _, received := range srv.collectFrames(time.Second) {
+		if received.Control {
+			continue
+		}
+
+		var dml DMLMessage
+		require.NoError(t, dml.Unmarshal(received.MessageData))
+		assert.Equal(t, byte(5), dml.ServiceID)
+		assert.Equal(t, byte(3), dml.OrderNumber)
+
+		var msg testMessage
+		require.NoError(t, msg.Unmarshal(dml.Packet))
+		assert.Equal(t, uint32(7), msg.Value)
+		return
+	}
+
+	t.Fatal("message was not received")
+}
+
+func TestReconnect(t *testing.T) {
+	srv := startTestServer(t)
+
+	reconnected := make(chan struct{}, 1)
+	client := dialTestServer(t, srv,
+		WithNoHeartbeat(),
+		WithReconnect(func(int) time.Duration { return 10 * time.Millisecond }),
+		OnReconnect(func(*Client) { reconnected <- struct{}{} }),
+	)
+	assert.Equal(t, StateConnected, client.State())
+
+	conn := <-srv.conns
+	conn.Close()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	<-srv.conns
+
+	assert.Equal(t, StateConnected, client.State())
+	assert.Equal(t, testSession.SessionID, client.SessionID())
+
+	require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 101}))
+	for _, frame := range srv.collectFrames(time.Second) {
+		if frame.Control {
+			continue
+		}
+
+		var dml DMLMessage
+		require.NoError(t, dml.Unmarshal(frame.MessageData))
+		assert.Equal(t, byte(5), dml.ServiceID)
+		return
+	}
+
+	t.Fatal("message was not received after reconnecting")
+}
+
+func TestOnReconnectWritesFirst(t *testing.T) {
+	srv := startTestServer(t)
+
+	client := dialTestServer(t, srv,
+		WithNoHeartbeat(),
+		WithReconnect(func(int) time.Duration { return 200 * time.Millisecond }),
+		OnReconnect(func(c *Client) {
+			require.NoError(t, c.WriteMessage(5, 9, &testMessage{Value: 9}))
+		}),
+	)
+
+	conn := <-srv.conns
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return client.State() == StateReconnecting
+	}, 5*time.Second, time.Millisecond)
+
+	// Queued during the outage, it must not reach the new session before the callback's message
+	queued := make(chan error, 1)
+	go func() {
+		queued <- client.WriteMessage(5, 1, &testMessage{Value: 1})
+	}()
+
+	var orders []byte
+	for _, frame := range srv.collectFrames(2 * time.Second) {
+		if frame.Control {
+			continue
+		}
+
+		var dml DMLMessage
+		require.NoError(t, dml.Unmarshal(frame.MessageData))
+		orders = append(orders, dml.OrderNumber)
+		if len(orders) == 2 {
+			break
+		}
+	}
+
+	assert.Equal(t, []byte{9, 1}, orders)
+	assert.NoError(t, <-queued)
+}
+
+func TestReconnectGiveUp(t *testing.T) {
+	srv := startTestServer(t)
+
+	client := dialTestServer(t, srv,
+		WithNoHeartbeat(),
+		WithReconnect(func(int) time.Duration { return -1 }),
+	)
+
+	conn := <-srv.conns
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return client.State() == StateClosed
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+
+	assert.Equal(t, 100*time.Millisecond, backoff(1))
+	assert.Equal(t, 200*time.Millisecond, backoff(2))
+	assert.Equal(t, 800*time.Millisecond, backoff(4))
+	assert.Equal(t, time.Second, backoff(5))
+	assert.Equal(t, time.Second, backoff(100))
+}
+
+func TestDialOptions(t *testing.T) {
+	srv := startTestServer(t)
+
+	var dialed atomic.Bool
+	dialer := &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			dialed.Store(true)
+			return nil
+		},
+	}
+
+	client := dialTestServer(t, srv,
+		WithDialer(dialer),
+		WithChannelBuffer(64),
+		WithHeartbeatInterval(10*time.Millisecond),
+	)
+	assert.True(t, dialed.Load())
+	assert.Equal(t, 64, cap(client.writeMessageCh))
+
+	var keepAlives int
+	for _, frame := range srv.collectFrames(200 * time.Millisecond) {
+		if frame.Opcode == control.PktSessionKeepAlive {
+			keepAlives++
+		}
+	}
+	assert.True(t, keepAlives > 1, "expected keepalives at the configured interval")
+}
+
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+func TestDialCustomDialer(t *testing.T) {
+	srv := startTestServer(t)
+
+	var dialed atomic.Value
+	dialer := dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed.Store(network + " " + address)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	})
+
+	dialTestServer(t, srv, WithDialer(dialer), WithNoHeartbeat())
+	assert.Equal(t, "tcp "+srv.addr, dialed.Load())
+}
+
+func TestDialInvalidOptions(t *testing.T) {
+	router := NewMessageRouter()
+
+	_, err := Dial(context.Background(), "127.0.0.1:0", &router, WithHeartbeatInterval(0))
+	assert.Error(t, err)
+
+	_, err = Dial(context.Background(), "127.0.0.1:0", &router, WithChannelBuffer(-1))
+	assert.Error(t, err)
+
+	_, err = Dial(context.Background(), "127.0.0.1:0", &router, WithRateLimit(10, 0))
+	assert.Error(t, err)
+}
+
+func TestWithRateLimit(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat(), WithRateLimit(rate.Every(200*time.Millisecond), 1))
+
+	countMessages := func(frames []*Frame) int {
+		var n int
+		for _, frame := range frames {
+			if !frame.Control {
+				n++
+			}
+		}
+		return n
+	}
+
+	// WriteMessage waits for the limiter, so write from another goroutine
+	go func() {
+		for i := range 3 {
+			client.WriteMessage(5, 1, &testMessage{Value: uint32(i)})
+		}
+	}()
+
+	// The first message uses the burst, the rest wait their turn
+	assert.Equal(t, 1, countMessages(srv.collectFrames(100*time.Millisecond)))
+	assert.Equal(t, 2, countMessages(srv.collectFrames(time.Second)))
+}
+
+func TestWithRateLimitControlFrames(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat(), WithRateLimit(rate.Every(time.Hour), 1))
+
+	// Use up the burst, then leave a message waiting on the limiter
+	require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 1}))
+	go client.WriteMessage(5, 1, &testMessage{Value: 2})
+	assert.Eventually(t, func() bool {
+		return client.limiter.Tokens() < 0
+	}, time.Second, time.Millisecond)
+
+	client.sendHeartbeat()
+
+	var keepAlives, messages int
+	for _, frame := range srv.collectFrames(500 * time.Millisecond) {
+		switch {
+		case frame.Control && frame.Opcode == control.PktSessionKeepAlive:
+			keepAlives++
+		case !frame.Control:
+			messages++
+		}
+	}
+
+	// The keepalive isn't held up behind the throttled message
+	assert.Equal(t, 1, keepAlives)
+	assert.Equal(t, 1, messages)
+}
+
+func TestDoneAndErr(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	select {
+	case <-client.Done():
+		t.Fatal("client is done before closing")
+	default:
+	}
+	assert.NoError(t, client.Err())
+
+	conn := <-srv.conns
+	conn.Close()
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+	assert.Error(t, client.Err())
+}
+
+func TestCloseErr(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	client.Close()
+
+	<-client.Done()
+	assert.NoError(t, client.Err())
+}
+
+func TestDialContextCancel(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		sc, err := l.Accept(context.Background())
+		if err != nil {
+			served <- err
+			return
+		}
+		served <- sc.Serve(&MessageRouter{})
+	}()
+
+	router := NewMessageRouter()
+	client, err := Dial(ctx, l.Addr().String(), &router, WithHeartbeatInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	cancel()
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+	assert.Equal(t, context.Canceled, client.Err())
+
+	// The server sees the connection close, then every goroutine should be gone
+	assert.Error(t, <-served)
+}
+
+func TestNewClient(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	frames := make(chan *Frame, 8)
+	go func() {
+		writer := FrameWriter{writer: serverConn}
+		offer := testSession
+		if err := writer.Write(&Frame{
+			Control:     true,
+			Opcode:      control.PktSessionOffer,
+			MessageData: offer.Marshal(),
+		}); err != nil {
+			return
+		}
+
+		reader := FrameReader{Reader: serverConn}
+		for {
+			frame, err := reader.Read()
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	router := NewMessageRouter()
+	client, err := NewClient(ctx, clientConn, &router, WithNoHeartbeat())
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, testSession.SessionID, client.SessionID())
+
+	select {
+	case frame := <-frames:
+		assert.Equal(t, control.PktSessionAccept, frame.Opcode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not accepted")
+	}
+}
+
+// sendTestMessage writes a DML message to the client on the other end of conn.
+func TestOnDecodeError(t *testing.T) {
+	srv := startTestServer(t)
+
+	received := make(chan testMessage, 1)
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) {
+		received <- msg
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var decodeErrs []error
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), OnDecodeError(func(_ *Frame, err error) {
+		decodeErrs = append(decodeErrs, err)
+	}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn := <-srv.conns
+	writer := FrameWriter{writer: conn}
+
+	// A frame too short to hold a message, then a message too short for its handler
+	require.NoError(t, writer.Write(&Frame{MessageData: []byte{5, 1}}))
+	short := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: []byte{1}}
+	require.NoError(t, writer.Write(&Frame{MessageData: short.Marshal()}))
+
+	sendTestMessage(t, conn, 5, 1, &testMessage{Value: 3})
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, uint32(3), msg.Value)
+	case <-ctx.Done():
+		t.Fatal("message after decode errors was not handled")
+	}
+
+	require.Len(t, decodeErrs, 2)
+	for _, err := range decodeErrs {
+		var decodeErr *DecodeError
+		assert.True(t, errors.As(err, &decodeErr))
+	}
+
+	var handlerErr *HandlerError
+	assert.True(t, errors.As(decodeErrs[1], &handlerErr))
+	assert.NoError(t, client.Err())
+	assert.Equal(t, uint64(2), client.Stats().UnmarshalErrors)
+}
+
+func TestDecodeErrorShutsDown(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	conn := <-srv.conns
+	writer := FrameWriter{writer: conn}
+	require.NoError(t, writer.Write(&Frame{MessageData: []byte{5, 1}}))
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+
+	var decodeErr *DecodeError
+	assert.True(t, errors.As(client.Err(), &decodeErr))
+}
+
+func sendTestMessage(t *testing.T, conn net.Conn, service, order byte, msg Message) {
+	dml := DMLMessage{
+		ServiceID:   service,
+		OrderNumber: order,
+		Packet:      msg.Marshal(),
+	}
+
+	writer := FrameWriter{writer: conn}
+	require.NoError(t, writer.Write(&Frame{MessageData: dml.Marshal()}))
+}
+
+func TestRegisterMessageHandlerE(t *testing.T) {
+	srv := startTestServer(t)
+
+	handlerErr := errors.New("handler failed")
+
+	router := NewMessageRouter()
+	RegisterMessageHandlerE(&router, 5, 1, func(msg testMessage) error {
+		return handlerErr
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat())
+	require.NoError(t, err)
+	defer client.Close()
+
+	sendTestMessage(t, <-srv.conns, 5, 1, &testMessage{Value: 1})
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+
+	var errHandler *HandlerError
+	require.True(t, errors.As(client.Err(), &errHandler))
+	assert.Equal(t, byte(5), errHandler.Service)
+	assert.Equal(t, byte(1), errHandler.Order)
+	assert.True(t, errors.Is(client.Err(), handlerErr))
+}
+
+func TestRegisterMiddlewareE(t *testing.T) {
+	router := NewMessageRouter()
+
+	middlewareErr := errors.New("middleware failed")
+	RegisterMiddlewareE(&router, func(msg testMessage) error {
+		return middlewareErr
+	})
+
+	var handled bool
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) {
+		handled = true
+	})
+
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: (&testMessage{Value: 1}).Marshal()}
+	assert.True(t, errors.Is(router.Handle(5, 1, dml), middlewareErr))
+	assert.False(t, handled)
+}
+
+func TestMessageRouterConcurrentRegister(t *testing.T) {
+	router := NewMessageRouter()
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: (&testMessage{Value: 1}).Marshal()}
+
+	var (
+		wg      sync.WaitGroup
+		handled atomic.Int32
+	)
+
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterMessageHandler(&router, 5, 1, func(testMessage) { handled.Add(1) })
+			RegisterMiddleware(&router, func(any) {})
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, router.Handle(5, 1, dml))
+		}()
+	}
+	wg.Wait()
+
+	handled.Store(0)
+	require.NoError(t, router.Handle(5, 1, dml))
+	assert.Equal(t, int32(8), handled.Load())
+}
+
+func TestMessageRouterUnregister(t *testing.T) {
+	router := NewMessageRouter()
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 1, Packet: (&testMessage{Value: 1}).Marshal()}
+
+	var first, second int
+	firstID := RegisterMessageHandler(&router, 5, 1, func(testMessage) { first++ })
+	RegisterMessageHandler(&router, 5, 1, func(testMessage) { second++ })
+
+	require.NoError(t, router.Handle(5, 1, dml))
+	router.Unregister(firstID)
+	require.NoError(t, router.Handle(5, 1, dml))
+
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 2, second)
+
+	// Removing a handler twice is a no-op
+	router.Unregister(firstID)
+	router.Unregister(HandlerID{})
+	require.NoError(t, router.Handle(5, 1, dml))
+	assert.Equal(t, 3, second)
+}
+
+func TestLastServerKeepAlive(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	assert.True(t, client.LastServerKeepAlive().IsZero())
+
+	conn := <-srv.conns
+	keepAlive := &control.ServerKeepAlive{SessionID: testSession.SessionID, UptimeMillis: 1000}
+
+	writer := FrameWriter{writer: conn}
+	require.NoError(t, writer.Write(&Frame{
+		Control:     true,
+		Opcode:      control.PktSessionKeepAlive,
+		MessageData: keepAlive.Marshal(),
+	}))
+
+	assert.Eventually(t, func() bool {
+		return !client.LastServerKeepAlive().IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, frame := range srv.collectFrames(time.Second) {
+		if frame.Opcode == control.PktSessionKeepAliveRsp {
+			return
+		}
+	}
+	t.Fatal("keepalive was not answered")
+}
+
+func TestOnUnhandled(t *testing.T) {
+	srv := startTestServer(t)
+
+	unhandled := make(chan *Frame, 8)
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(testMessage) {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), OnUnhandled(func(frame *Frame) {
+		unhandled <- frame
+	}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn := <-srv.conns
+	sendTestMessage(t, conn, 5, 1, &testMessage{Value: 1})
+	sendTestMessage(t, conn, 5, 2, &testMessage{Value: 2})
+
+	writer := FrameWriter{writer: conn}
+	require.NoError(t, writer.Write(&Frame{Control: true, Opcode: 0x7F}))
+
+	// Control frames and messages are handled separately so may be reported in either order
+	var controlFrame, messageFrame *Frame
+	for range 2 {
+		select {
+		case frame := <-unhandled:
+			if frame.Control {
+				controlFrame = frame
+			} else {
+				messageFrame = frame
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("unhandled frame was not reported")
+		}
+	}
+
+	require.NotNil(t, controlFrame)
+	assert.Equal(t, Opcode(0x7F), controlFrame.Opcode)
+
+	require.NotNil(t, messageFrame)
+	var dml DMLMessage
+	require.NoError(t, dml.Unmarshal(messageFrame.MessageData))
+	assert.Equal(t, byte(2), dml.OrderNumber)
+
+	select {
+	case frame := <-unhandled:
+		t.Fatalf("unexpected unhandled frame %+v", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	srv := startTestServer(t)
+
+	handled := make(chan uint32, 1)
+	panics := make(chan *HandlerError, 1)
+
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(testMessage) {
+		panic("handler bug")
+	})
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) {
+		handled <- msg.Value
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), OnPanic(func(err *HandlerError) {
+		panics <- err
+	}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sendTestMessage(t, <-srv.conns, 5, 1, &testMessage{Value: 101})
+
+	select {
+	case v := <-handled:
+		assert.Equal(t, uint32(101), v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("second handler did not run")
+	}
+
+	select {
+	case err := <-panics:
+		var panicErr *PanicError
+		require.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "handler bug", panicErr.Value)
+		assert.Equal(t, byte(5), err.Service)
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic was not reported")
+	}
+
+	select {
+	case <-client.Done():
+		t.Fatal("client shut down after a handler panicked")
+	default:
+	}
+	assert.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 1}))
+}
+
+func TestWithCredentials(t *testing.T) {
+	srv := startTestServer(t)
+	dialTestServer(t, srv, WithNoHeartbeat(), WithCredentials("1", "1"))
+
+	for _, frame := range srv.collectFrames(time.Second) {
+		if frame.Opcode != control.PktSessionAccept {
+			continue
+		}
+
+		var accept control.SessionAccept
+		require.NoError(t, accept.Unmarshal(frame.MessageData))
+
+		// Same vector as login.TestEncryptRec1, the test session has the same parameters
+		expected := "VLZpUqHY04cULJ+dvYknBM2Y3xynINN3gB4svovYA0jzWUsVAXjdtz363K9pC049fhpK9zFjlGaC6awzXmUCeKMseu7+Bol3JiFmN46MAv6fOQ7pNvD6RFlpzzjZ8rQ="
+		assert.Equal(t, expected, base64.StdEncoding.EncodeToString(accept.EncryptedMessage))
+		return
+	}
+
+	t.Fatal("session accept was not received")
+}
+
+func TestWithCredentialsPerSession(t *testing.T) {
+	offer := control.SessionOffer{
+		SessionID:  4711,
+		TimeSecs:   1700000000,
+		TimeMillis: 123,
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	accepted := make(chan control.SessionAccept, 1)
+	go func() {
+		writer := FrameWriter{writer: serverConn}
+		writer.Write(&Frame{
+			Control:     true,
+			Opcode:      control.PktSessionOffer,
+			MessageData: offer.Marshal(),
+		})
+
+		reader := FrameReader{Reader: serverConn}
+		for {
+			frame, err := reader.Read()
+			if err != nil {
+				return
+			}
+
+			var accept control.SessionAccept
+			if frame.Opcode == control.PktSessionAccept && accept.Unmarshal(frame.MessageData) == nil {
+				accepted <- accept
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	router := NewMessageRouter()
+	client, err := NewClient(ctx, clientConn, &router, WithNoHeartbeat(), WithCredentials("user", "hunter2"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	accept := <-accepted
+
+	// CK1 must be generated from this offer's parameters rather than any fixed session
+	ck1 := login.GenerateCK1("hunter2", offer.SessionID, offer.TimeSecs, offer.TimeMillis)
+	expected := login.AuthenToken("user", ck1, offer.SessionID)
+	token := login.DecryptRec1(accept.EncryptedMessage, offer.SessionID, offer.TimeSecs, offer.TimeMillis)
+	assert.Equal(t, string(expected), string(token))
+}
+
+func TestKeepAliveLatency(t *testing.T) {
+	now := time.Date(2021, time.April, 7, 17, 14, 55, 0, time.UTC)
+
+	opts := defaultClientOptions()
+	opts.now = func() time.Time { return now }
+
+	client := &Client{
+		opts:           opts,
+		writeMessageCh: make(chan *Frame, 8),
+	}
+	assert.Equal(t, time.Duration(0), client.KeepAliveLatency())
+
+	client.sendHeartbeat()
+	now = now.Add(42 * time.Millisecond)
+	client.handleControlFrame(keepAliveRsp())
+	assert.Equal(t, 42*time.Millisecond, client.KeepAliveLatency())
+
+	// A response without an outstanding keepalive doesn't change the measurement
+	now = now.Add(time.Second)
+	client.handleControlFrame(keepAliveRsp())
+	assert.Equal(t, 42*time.Millisecond, client.KeepAliveLatency())
+}
+
+func TestOnRawFrame(t *testing.T) {
+	srv := startTestServer(t)
+
+	frames := make(chan *Frame, 8)
+	dialTestServer(t, srv, WithNoHeartbeat(), OnRawFrame(func(frame *Frame) {
+		frames <- frame
+	}))
+
+	writer := FrameWriter{writer: <-srv.conns}
+	require.NoError(t, writer.Write(&Frame{Control: true, Opcode: 0x7F, MessageData: []byte{0x1}}))
+
+	var opcodes []Opcode
+	for range 2 {
+		select {
+		case frame := <-frames:
+			opcodes = append(opcodes, frame.Opcode)
+		case <-time.After(5 * time.Second):
+			t.Fatal("raw frame was not reported")
+		}
+	}
+	assert.Equal(t, []Opcode{control.PktSessionOffer, 0x7F}, opcodes)
+}
+
+func TestWithMaxFrameSize(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat(), WithMaxFrameSize(64))
+
+	writer := FrameWriter{writer: <-srv.conns}
+	require.NoError(t, writer.Write(&Frame{Opcode: 0x1, MessageData: make([]byte, 128)}))
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+	assert.True(t, errors.Is(client.Err(), ErrFrameTooLarge))
+}
+
+func TestWithServerKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	rawMessage := []byte("offer")
+	hash := sha256.Sum256(rawMessage)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+
+	signed := testSession
+	signed.RawMessage = rawMessage
+	signed.Signature = signature
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		writer := FrameWriter{writer: serverConn}
+		writer.Write(&Frame{
+			Control:     true,
+			Opcode:      control.PktSessionOffer,
+			MessageData: signed.Marshal(),
+		})
+
+		reader := FrameReader{Reader: serverConn}
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	router := NewMessageRouter()
+	client, err := NewClient(ctx, clientConn, &router, WithNoHeartbeat(), WithServerKey(&key.PublicKey))
+	require.NoError(t, err)
+	client.Close()
+
+	// The test server's offer is unsigned
+	srv := startTestServer(t)
+	_, err = Dial(ctx, srv.addr, &router, WithNoHeartbeat(), WithServerKey(&key.PublicKey))
+	assert.True(t, errors.Is(err, control.ErrInvalidSignature))
+}
+
+func TestWithFrameMagic(t *testing.T) {
+	const magic = 0xBEEF
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	accepted := make(chan *Frame, 1)
+	go func() {
+		writer := FrameWriter{writer: serverConn, Magic: magic}
+		offer := testSession
+		writer.Write(&Frame{
+			Control:     true,
+			Opcode:      control.PktSessionOffer,
+			MessageData: offer.Marshal(),
+		})
+
+		reader := FrameReader{Reader: serverConn, Magic: magic}
+		frame, err := reader.Read()
+		if err != nil {
+			return
+		}
+		accepted <- frame
+
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	router := NewMessageRouter()
+	client, err := NewClient(ctx, clientConn, &router, WithNoHeartbeat(), WithFrameMagic(magic))
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case frame := <-accepted:
+		assert.Equal(t, control.PktSessionAccept, frame.Opcode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session accept was not received")
+	}
+
+	// The test server uses the default magic
+	srv := startTestServer(t)
+	_, err = Dial(ctx, srv.addr, &router, WithNoHeartbeat(), WithFrameMagic(magic))
+	var decodeErr *DecodeError
+	assert.True(t, errors.As(err, &decodeErr))
+}
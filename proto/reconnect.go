@@ -0,0 +1,45 @@
+package proto
+
+import "time"
+
+// ConnState is the state of a client's connection to the server.
+type ConnState int32
+
+const (
+	StateConnecting ConnState = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Backoff returns how long to wait before a reconnect attempt, counting attempts from 1. A
+// negative duration stops reconnecting and closes the client.
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff waits initial before the first attempt and doubles the wait for each attempt
+// after, up to max. It never gives up.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		delay := initial
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+
+		return min(delay, max)
+	}
+}
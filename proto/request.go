@@ -0,0 +1,81 @@
+package proto
+
+import (
+	"context"
+	"slices"
+)
+
+type route struct {
+	service, order byte
+}
+
+// pendingRequests tracks callers of Request waiting on a response. Waiters on the same route are
+// served in the order they were registered.
+type pendingRequests struct {
+	waiters map[route][]chan DMLMessage
+}
+
+func (p *pendingRequests) add(r route) chan DMLMessage {
+	if p.waiters == nil {
+		p.waiters = make(map[route][]chan DMLMessage)
+	}
+
+	ch := make(chan DMLMessage, 1)
+	p.waiters[r] = append(p.waiters[r], ch)
+
+	return ch
+}
+
+func (p *pendingRequests) remove(r route, ch chan DMLMessage) {
+	p.waiters[r] = slices.DeleteFunc(p.waiters[r], func(w chan DMLMessage) bool {
+		return w == ch
+	})
+}
+
+// deliver hands d to the oldest waiter on its route. It reports false if there are no waiters.
+func (p *pendingRequests) deliver(d DMLMessage) bool {
+	r := route{d.ServiceID, d.OrderNumber}
+
+	waiters := p.waiters[r]
+	if len(waiters) == 0 {
+		return false
+	}
+
+	waiters[0] <- d
+	p.waiters[r] = waiters[1:]
+
+	return true
+}
+
+// Request writes msg and waits for the next message on respService and respOrder, which is
+// unmarshaled into out. Handlers registered for the response still receive it. Request returns
+// early if ctx expires or the client is closed.
+func (c *Client) Request(ctx context.Context, service, order byte, msg Message, respService, respOrder byte, out MessageUnmarshaler) error {
+	r := route{respService, respOrder}
+
+	c.pendingMu.Lock()
+	ch := c.pending.add(r)
+	c.pendingMu.Unlock()
+
+	cancel := func() {
+		c.pendingMu.Lock()
+		c.pending.remove(r, ch)
+		c.pendingMu.Unlock()
+	}
+
+	if err := c.WriteMessage(service, order, msg); err != nil {
+		cancel()
+		return err
+	}
+
+	select {
+	case d := <-ch:
+		return out.Unmarshal(d.Packet)
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	case <-c.done:
+		cancel()
+		return ErrClientClosed
+	}
+}
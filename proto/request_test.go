@@ -0,0 +1,66 @@
+package proto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequest(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	conn := <-srv.conns
+
+	// Echo each request back on order 2 with its value incremented
+	go func() {
+		writer := FrameWriter{writer: conn}
+
+		for frame := range srv.frames {
+			if frame.Control {
+				continue
+			}
+
+			var (
+				dml DMLMessage
+				req testMessage
+			)
+			if dml.Unmarshal(frame.MessageData) != nil || req.Unmarshal(dml.Packet) != nil {
+				return
+			}
+
+			resp := DMLMessage{
+				ServiceID:   dml.ServiceID,
+				OrderNumber: 2,
+				Packet:      (&testMessage{Value: req.Value + 1}).Marshal(),
+			}
+			writer.Write(&Frame{MessageData: resp.Marshal()})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var resp testMessage
+	require.NoError(t, client.Request(ctx, 5, 1, &testMessage{Value: 100}, 5, 2, &resp))
+	assert.Equal(t, uint32(101), resp.Value)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var resp testMessage
+	err := client.Request(ctx, 5, 1, &testMessage{Value: 100}, 5, 2, &resp)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	client.pendingMu.Lock()
+	assert.Empty(t, client.pending.waiters[route{5, 2}])
+	client.pendingMu.Unlock()
+}
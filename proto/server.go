@@ -0,0 +1,281 @@
+package proto
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cedws/w101-client-go/proto/control"
+)
+
+type ListenOption func(*listenOptions)
+
+type listenOptions struct {
+	offerKey     *rsa.PrivateKey
+	batchedReads bool
+}
+
+// WithOfferKey makes the listener sign its session offers with key, so that clients using
+// WithServerKey with the matching public key accept them.
+func WithOfferKey(key *rsa.PrivateKey) ListenOption {
+	return func(o *listenOptions) {
+		o.offerKey = key
+	}
+}
+
+// WithBatchedServerReads makes accepted connections decode every message packed into an inbound
+// frame, as written by Client.WriteMessages. By default a frame is decoded as a single message.
+func WithBatchedServerReads() ListenOption {
+	return func(o *listenOptions) {
+		o.batchedReads = true
+	}
+}
+
+// Listener accepts connections from clients, acting as the server side of the protocol. It's
+// intended for test servers and emulators.
+type Listener struct {
+	listener net.Listener
+	opts     listenOptions
+}
+
+// Listen listens for clients on the TCP address addr.
+func Listen(addr string, opts ...ListenOption) (*Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewListener(listener, opts...), nil
+}
+
+// NewListener accepts clients from an existing listener.
+func NewListener(listener net.Listener, opts ...ListenOption) *Listener {
+	l := &Listener{listener: listener}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+
+	return l
+}
+
+// Addr returns the address the listener is listening on.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops the listener. Connections already accepted are left open.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// Accept waits for a client to connect, offers it a session and waits for the client to accept
+// it. If ctx expires during the handshake, the connection is closed and ctx's error is returned.
+func (l *Listener) Accept(ctx context.Context) (*ServerConn, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &ServerConn{
+		conn:   conn,
+		reader: FrameReader{Reader: conn},
+		writer: FrameWriter{writer: conn},
+		done:   make(chan struct{}),
+
+		batchedReads: l.opts.batchedReads,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sc.offerSession(l.opts)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	case <-ctx.Done():
+		conn.Close()
+		<-errCh
+		return nil, ctx.Err()
+	}
+
+	return sc, nil
+}
+
+// ServerConn is a client connection accepted by a Listener.
+type ServerConn struct {
+	conn    net.Conn
+	reader  FrameReader
+	session Session
+
+	writeMu sync.Mutex
+	writer  FrameWriter
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	batchedReads bool
+}
+
+func (sc *ServerConn) offerSession(opts listenOptions) error {
+	id, err := rand.Int(rand.Reader, big.NewInt(1<<16))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	offer := control.SessionOffer{
+		SessionID:  uint16(id.Uint64()),
+		TimeSecs:   uint32(now.Unix()),
+		TimeMillis: uint32(now.Nanosecond() / 1_000_000),
+	}
+
+	if opts.offerKey != nil {
+		offer.RawMessage = []byte{0}
+		hash := sha256.Sum256(offer.RawMessage)
+		if offer.Signature, err = rsa.SignPKCS1v15(rand.Reader, opts.offerKey, crypto.SHA256, hash[:]); err != nil {
+			return err
+		}
+	}
+
+	if err := sc.writeFrame(&Frame{
+		Control:     true,
+		Opcode:      control.PktSessionOffer,
+		MessageData: offer.Marshal(),
+	}); err != nil {
+		return err
+	}
+
+	for {
+		frame, err := sc.reader.Read()
+		if err != nil {
+			return fmt.Errorf("connection closed before handshake: %w", err)
+		}
+		if !frame.Control || frame.Opcode != control.PktSessionAccept {
+			continue
+		}
+
+		accept := &control.SessionAccept{}
+		if err := accept.Unmarshal(frame.MessageData); err != nil {
+			return err
+		}
+		if accept.SessionID != offer.SessionID {
+			return fmt.Errorf("proto: client accepted session %v but %v was offered", accept.SessionID, offer.SessionID)
+		}
+
+		sc.session = Session{
+			ID:         offer.SessionID,
+			TimeSecs:   offer.TimeSecs,
+			TimeMillis: offer.TimeMillis,
+			Start:      now,
+		}
+
+		return nil
+	}
+}
+
+// Serve reads from the connection until it's closed, answering keepalives and passing messages
+// to router. It returns nil if the connection was closed with Close, or the error that ended it.
+// A handler failing ends the connection with a *HandlerError, handler panics are skipped.
+func (sc *ServerConn) Serve(router *MessageRouter) error {
+	defer sc.Close()
+
+	for {
+		frame, err := sc.reader.Read()
+		if err != nil {
+			select {
+			case <-sc.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if frame.Control {
+			if frame.Opcode == control.PktSessionKeepAlive {
+				if err := sc.writeFrame(keepAliveRsp()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		msgs, err := decodeMessages(frame.MessageData, sc.batchedReads)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range msgs {
+			if err := router.Handle(d.ServiceID, d.OrderNumber, d); err != nil && !onlyPanics(err) {
+				return &HandlerError{
+					Service: d.ServiceID,
+					Order:   d.OrderNumber,
+					Err:     err,
+				}
+			}
+		}
+	}
+}
+
+// Session returns the session agreed with the client.
+func (sc *ServerConn) Session() Session {
+	return sc.session
+}
+
+// RemoteAddr returns the address of the client.
+func (sc *ServerConn) RemoteAddr() net.Addr {
+	return sc.conn.RemoteAddr()
+}
+
+// WriteMessage writes a message to the client. It returns ErrClientClosed if the connection has
+// been closed.
+func (sc *ServerConn) WriteMessage(service, order byte, msg Message) error {
+	dml := DMLMessage{
+		ServiceID:   service,
+		OrderNumber: order,
+		Packet:      msg.Marshal(),
+	}
+
+	return sc.writeFrame(&Frame{
+		MessageData: dml.Marshal(),
+	})
+}
+
+// WriteRawFrame writes a caller-built frame to the client as-is.
+func (sc *ServerConn) WriteRawFrame(frame *Frame) error {
+	return sc.writeFrame(frame)
+}
+
+func (sc *ServerConn) writeFrame(frame *Frame) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	select {
+	case <-sc.done:
+		return ErrClientClosed
+	default:
+	}
+
+	return sc.writer.Write(frame)
+}
+
+// Close closes the connection.
+func (sc *ServerConn) Close() error {
+	var err error
+	sc.closeOnce.Do(func() {
+		close(sc.done)
+		err = sc.conn.Close()
+	})
+
+	return err
+}
@@ -0,0 +1,180 @@
+package proto
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func acceptOne(t *testing.T, ctx context.Context, l *Listener) <-chan *ServerConn {
+	t.Helper()
+
+	ch := make(chan *ServerConn, 1)
+	go func() {
+		defer close(ch)
+
+		sc, err := l.Accept(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- sc
+	}()
+
+	return ch
+}
+
+func TestListen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := acceptOne(t, ctx, l)
+
+	clientRouter := NewMessageRouter()
+	received := make(chan testMessage, 1)
+	RegisterMessageHandler(&clientRouter, 5, 2, func(msg testMessage) {
+		received <- msg
+	})
+
+	client, err := Dial(ctx, l.Addr().String(), &clientRouter, WithHeartbeatInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sc := <-accepted
+	require.NotNil(t, sc)
+	defer sc.Close()
+
+	assert.Equal(t, sc.Session().ID, client.SessionID())
+	assert.Equal(t, sc.Session().TimeSecs, client.SessionTimeSecs())
+
+	serverRouter := NewMessageRouter()
+	serverReceived := make(chan testMessage, 1)
+	RegisterMessageHandler(&serverRouter, 5, 1, func(msg testMessage) {
+		serverReceived <- msg
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- sc.Serve(&serverRouter)
+	}()
+
+	require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 1}))
+	select {
+	case msg := <-serverReceived:
+		assert.Equal(t, uint32(1), msg.Value)
+	case <-ctx.Done():
+		t.Fatal("server did not receive message")
+	}
+
+	require.NoError(t, sc.WriteMessage(5, 2, &testMessage{Value: 2}))
+	select {
+	case msg := <-received:
+		assert.Equal(t, uint32(2), msg.Value)
+	case <-ctx.Done():
+		t.Fatal("client did not receive message")
+	}
+
+	assert.Eventually(t, func() bool {
+		return client.KeepAliveLatency() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, sc.Close())
+	assert.NoError(t, <-served)
+	assert.Equal(t, ErrClientClosed, sc.WriteMessage(5, 2, &testMessage{}))
+}
+
+func TestListenWithOfferKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	l, err := Listen("127.0.0.1:0", WithOfferKey(key))
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := acceptOne(t, ctx, l)
+
+	router := NewMessageRouter()
+	client, err := Dial(ctx, l.Addr().String(), &router, WithNoHeartbeat(), WithServerKey(&key.PublicKey))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sc := <-accepted
+	require.NotNil(t, sc)
+	sc.Close()
+}
+
+func TestListenerAcceptContext(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := l.Accept(ctx)
+		errCh <- err
+	}()
+
+	// Connect without ever accepting the offered session
+	conn, err := (&net.Dialer{}).DialContext(context.Background(), "tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errCh)
+}
+
+func TestServeBatchedReads(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l, err := Listen("127.0.0.1:0", WithBatchedServerReads())
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := acceptOne(t, ctx, l)
+
+	clientRouter := NewMessageRouter()
+	client, err := Dial(ctx, l.Addr().String(), &clientRouter, WithNoHeartbeat())
+	require.NoError(t, err)
+	defer client.Close()
+
+	sc := <-accepted
+	require.NotNil(t, sc)
+	defer sc.Close()
+
+	serverRouter := NewMessageRouter()
+	received := make(chan uint32, 2)
+	RegisterMessageHandler(&serverRouter, 5, 1, func(msg testMessage) {
+		received <- msg.Value
+	})
+	go sc.Serve(&serverRouter)
+
+	require.NoError(t, client.WriteMessages([]OutgoingMessage{
+		{Service: 5, Order: 1, Message: &testMessage{Value: 1}},
+		{Service: 5, Order: 1, Message: &testMessage{Value: 2}},
+	}))
+
+	for i := range uint32(2) {
+		select {
+		case v := <-received:
+			assert.Equal(t, i+1, v)
+		case <-ctx.Done():
+			t.Fatal("server did not receive batched message")
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package proto
+
+import "context"
+
+// drainMarker is queued behind every other frame by Shutdown. It isn't written, the write
+// goroutine closes Client.drained when it reaches it.
+var drainMarker = &Frame{}
+
+// Shutdown stops the client accepting new writes, waits for the frames already queued to be
+// written and then closes the client. If ctx expires first, the client is closed without
+// waiting and ctx's error is returned. Writes made after Shutdown is called return
+// ErrClientClosed.
+func (c *Client) Shutdown(ctx context.Context) error {
+	// Wait for sends in progress to finish queueing before stopping further sends. A send can be
+	// blocked on a full queue, so this is done off to the side in case ctx expires first.
+	stopped := make(chan bool, 1)
+	go func() {
+		stopped <- c.stopWrites()
+	}()
+
+	select {
+	case first := <-stopped:
+		// Another call is already draining the queue
+		if !first {
+			select {
+			case <-c.done:
+				return nil
+			case <-ctx.Done():
+				c.Close()
+				return ctx.Err()
+			}
+		}
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	case <-c.done:
+		return c.Close()
+	}
+
+	select {
+	case c.writeMessageCh <- drainMarker:
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	case <-c.done:
+		return c.Close()
+	}
+
+	select {
+	case <-c.drained:
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	case <-c.done:
+	}
+
+	return c.Close()
+}
+
+// stopWrites stops further frames being queued. It reports whether writes were running before.
+func (c *Client) stopWrites() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.writesStopped {
+		return false
+	}
+
+	c.writesStopped = true
+	return true
+}
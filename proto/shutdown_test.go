@@ -0,0 +1,61 @@
+package proto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	srv := startTestServer(t)
+	client := dialTestServer(t, srv, WithNoHeartbeat(), WithChannelBuffer(256))
+
+	const count = 200
+	for i := range count {
+		require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: uint32(i)}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, client.Shutdown(ctx))
+	assert.True(t, errors.Is(client.WriteMessage(5, 1, &testMessage{}), ErrClientClosed))
+
+	var received int
+	timeout := time.After(5 * time.Second)
+	for received < count {
+		select {
+		case frame := <-srv.frames:
+			if !frame.Control {
+				received++
+			}
+		case <-timeout:
+			t.Fatalf("received %v of %v messages", received, count)
+		}
+	}
+
+	// Shutting down again is harmless
+	assert.NoError(t, client.Shutdown(ctx))
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	client := pipeClient(t, WithNoHeartbeat())
+
+	// Nothing reads from the pipe, so the queued message can't be written
+	require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 1}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, client.Shutdown(ctx))
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not closed")
+	}
+}
@@ -0,0 +1,65 @@
+package proto
+
+import "sync/atomic"
+
+// Stats is a snapshot of a client's counters. Counters accumulate across reconnects.
+type Stats struct {
+	// FramesRead is the number of frames read, including control frames
+	FramesRead uint64
+	// FramesWritten is the number of frames written, including control frames
+	FramesWritten uint64
+	// MessagesRouted is the number of DML messages passed to pending requests and handlers
+	MessagesRouted uint64
+	// UnmarshalErrors is the number of message frames that couldn't be split into DML messages
+	UnmarshalErrors uint64
+	// BytesRead is the number of bytes read, including frame headers
+	BytesRead uint64
+	// BytesWritten is the number of bytes written, including frame headers
+	BytesWritten uint64
+}
+
+type clientStats struct {
+	framesRead      atomic.Uint64
+	framesWritten   atomic.Uint64
+	messagesRouted  atomic.Uint64
+	unmarshalErrors atomic.Uint64
+	bytesRead       atomic.Uint64
+	bytesWritten    atomic.Uint64
+}
+
+// Stats returns a snapshot of the client's counters. The counters are read individually so the
+// snapshot may be slightly inconsistent while the client is running.
+func (c *Client) Stats() Stats {
+	return Stats{
+		FramesRead:      c.stats.framesRead.Load(),
+		FramesWritten:   c.stats.framesWritten.Load(),
+		MessagesRouted:  c.stats.messagesRouted.Load(),
+		UnmarshalErrors: c.stats.unmarshalErrors.Load(),
+		BytesRead:       c.stats.bytesRead.Load(),
+		BytesWritten:    c.stats.bytesWritten.Load(),
+	}
+}
+
+func (s *clientStats) recordRead(frame *Frame) {
+	s.framesRead.Add(1)
+	// The body read includes the opcode header and the trailing NUL
+	s.bytesRead.Add(wireSize(4 + len(frame.MessageData)))
+}
+
+func (s *clientStats) recordWritten(frame *Frame) {
+	s.framesWritten.Add(1)
+	// The body written is the opcode header, the message data and a trailing NUL
+	s.bytesWritten.Add(wireSize(4 + len(frame.MessageData) + 1))
+}
+
+// wireSize returns the size of a frame on the wire given the length of its body
+func wireSize(bodyLen int) uint64 {
+	// magic and length
+	size := 2 + 2
+	if bodyLen > 0x7FFF {
+		// extended length
+		size += 4
+	}
+
+	return uint64(size + bodyLen)
+}
@@ -0,0 +1,52 @@
+package proto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	srv := startTestServer(t)
+
+	handled := make(chan struct{}, 1)
+
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(msg testMessage) {
+		handled <- struct{}{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat())
+	require.NoError(t, err)
+	defer client.Close()
+
+	sendTestMessage(t, <-srv.conns, 5, 1, &testMessage{Value: 1})
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not handled")
+	}
+
+	before := client.Stats()
+	require.NoError(t, client.WriteRawFrame(&Frame{Opcode: 0x7F, MessageData: []byte{0x1, 0x2}}))
+
+	assert.Eventually(t, func() bool {
+		return client.Stats().FramesWritten == before.FramesWritten+1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stats := client.Stats()
+	// Session offer and the message
+	assert.Equal(t, uint64(2), stats.FramesRead)
+	assert.Equal(t, uint64(1), stats.MessagesRouted)
+	assert.Zero(t, stats.UnmarshalErrors)
+	assert.NotZero(t, stats.BytesRead)
+	// Frame header, opcode header, data and NUL
+	assert.Equal(t, uint64(4+4+2+1), stats.BytesWritten-before.BytesWritten)
+}
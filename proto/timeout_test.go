@@ -0,0 +1,104 @@
+package proto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cedws/w101-client-go/proto/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeClient connects a client to the end of a pipe which offers a session, reads the accept and
+// then stops reading.
+func pipeClient(t *testing.T, opts ...Option) *Client {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() { serverConn.Close() })
+
+	go func() {
+		writer := FrameWriter{writer: serverConn}
+		offer := testSession
+		if err := writer.Write(&Frame{
+			Control:     true,
+			Opcode:      control.PktSessionOffer,
+			MessageData: offer.Marshal(),
+		}); err != nil {
+			return
+		}
+
+		reader := FrameReader{Reader: serverConn}
+		reader.Read()
+	}()
+
+	// The client shuts down once its context is cancelled, so it lives until the test ends
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	router := NewMessageRouter()
+	client, err := NewClient(ctx, clientConn, &router, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func waitDone(t *testing.T, client *Client) {
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	client := pipeClient(t, WithNoHeartbeat(), WithReadTimeout(50*time.Millisecond))
+
+	waitDone(t, client)
+	assert.True(t, errors.Is(client.Err(), ErrReadTimeout))
+	assert.False(t, errors.Is(client.Err(), ErrConnectionDead))
+	assert.True(t, errors.Is(client.Err(), os.ErrDeadlineExceeded))
+}
+
+func TestLivenessCheck(t *testing.T) {
+	// The server never answers, so the connection is dead after three heartbeat intervals
+	client := pipeClient(t, WithHeartbeatInterval(20*time.Millisecond), WithLivenessCheck(3))
+
+	waitDone(t, client)
+	assert.True(t, errors.Is(client.Err(), ErrConnectionDead))
+	assert.True(t, errors.Is(client.Err(), ErrReadTimeout))
+	assert.True(t, errors.Is(client.Err(), os.ErrDeadlineExceeded))
+}
+
+func TestLivenessCheckInvalid(t *testing.T) {
+	router := NewMessageRouter()
+
+	_, err := Dial(context.Background(), "127.0.0.1:0", &router, WithLivenessCheck(1))
+	assert.Error(t, err)
+}
+
+func TestLivenessCheckWithReadTimeout(t *testing.T) {
+	router := NewMessageRouter()
+
+	_, err := Dial(context.Background(), "127.0.0.1:0", &router, WithLivenessCheck(3), WithReadTimeout(time.Minute))
+	assert.ErrorContains(t, err, "read timeout and liveness check")
+}
+
+func TestWriteTimeout(t *testing.T) {
+	client := pipeClient(t, WithNoHeartbeat(), WithWriteTimeout(50*time.Millisecond))
+
+	require.NoError(t, client.WriteMessage(5, 1, &testMessage{Value: 1}))
+
+	waitDone(t, client)
+	assert.True(t, errors.Is(client.Err(), os.ErrDeadlineExceeded))
+}
+
+func TestReadTimeoutShorterThanHeartbeat(t *testing.T) {
+	router := NewMessageRouter()
+
+	_, err := Dial(context.Background(), "127.0.0.1:0", &router, WithReadTimeout(time.Second))
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package proto
+
+import "context"
+
+// Tracer starts spans around the client's work, so that it can be traced with a library such as
+// OpenTelemetry without the client depending on it. An adapter for an OpenTelemetry tracer
+// starts a span with the name and attributes given, and ends it recording the error if any.
+//
+// Spans are started for the session handshake, for each message handled and for each frame
+// written. Message and write spans have no parent, their context is context.Background.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attrs ...Attribute) Span
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	// End finishes the span. err is the error the work failed with, or nil.
+	End(err error)
+}
+
+// Attribute describes a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Names of the spans started by the client
+const (
+	SpanHandshake = "proto.handshake"
+	SpanHandle    = "proto.handle"
+	SpanWrite     = "proto.write"
+)
+
+// Keys of the span attributes set by the client
+const (
+	AttrService = "proto.service"
+	AttrOrder   = "proto.order"
+	AttrSize    = "proto.size"
+	AttrControl = "proto.control"
+	AttrOpcode  = "proto.opcode"
+	AttrRemote  = "proto.remote"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+func (c *Client) traceHandshake(ctx context.Context, cn *connection) Span {
+	if c.opts.tracer == nil {
+		return noopSpan{}
+	}
+
+	return c.opts.tracer.StartSpan(ctx, SpanHandshake,
+		Attribute{AttrRemote, cn.conn.RemoteAddr().String()},
+	)
+}
+
+func (c *Client) traceHandle(d DMLMessage) Span {
+	if c.opts.tracer == nil {
+		return noopSpan{}
+	}
+
+	return c.opts.tracer.StartSpan(context.Background(), SpanHandle,
+		Attribute{AttrService, d.ServiceID},
+		Attribute{AttrOrder, d.OrderNumber},
+		Attribute{AttrSize, len(d.Packet)},
+	)
+}
+
+func (c *Client) traceWrite(frame *Frame) Span {
+	if c.opts.tracer == nil {
+		return noopSpan{}
+	}
+
+	attrs := []Attribute{
+		{AttrControl, frame.Control},
+		{AttrSize, wireSize(4 + len(frame.MessageData) + 1)},
+	}
+	if frame.Control {
+		attrs = append(attrs, Attribute{AttrOpcode, frame.Opcode.String()})
+	}
+
+	return c.opts.tracer.StartSpan(context.Background(), SpanWrite, attrs...)
+}
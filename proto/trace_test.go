@@ -0,0 +1,107 @@
+package proto
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]any
+	ended bool
+	err   error
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(_ context.Context, name string, attrs ...Attribute) Span {
+	span := &testSpan{name: name, attrs: make(map[string]any)}
+	for _, attr := range attrs {
+		span.attrs[attr.Key] = attr.Value
+	}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+
+	return testSpanEnder{t, span}
+}
+
+// ended returns the spans with the given name that have ended
+func (t *testTracer) ended(name string) []testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var spans []testSpan
+	for _, span := range t.spans {
+		if span.name == name && span.ended {
+			spans = append(spans, *span)
+		}
+	}
+	return spans
+}
+
+type testSpanEnder struct {
+	tracer *testTracer
+	span   *testSpan
+}
+
+func (s testSpanEnder) End(err error) {
+	s.tracer.mu.Lock()
+	s.span.ended = true
+	s.span.err = err
+	s.tracer.mu.Unlock()
+}
+
+func TestWithTracer(t *testing.T) {
+	srv := startTestServer(t)
+	tracer := &testTracer{}
+
+	handled := make(chan struct{}, 1)
+	router := NewMessageRouter()
+	RegisterMessageHandler(&router, 5, 1, func(testMessage) {
+		handled <- struct{}{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, srv.addr, &router, WithNoHeartbeat(), WithTracer(tracer))
+	require.NoError(t, err)
+	defer client.Close()
+
+	handshakes := tracer.ended(SpanHandshake)
+	require.Len(t, handshakes, 1)
+	assert.NoError(t, handshakes[0].err)
+	assert.Equal(t, srv.addr, handshakes[0].attrs[AttrRemote])
+
+	conn := <-srv.conns
+	sendTestMessage(t, conn, 5, 1, &testMessage{Value: 1})
+	<-handled
+
+	require.NoError(t, client.WriteMessage(5, 2, &testMessage{Value: 2}))
+	<-srv.frames // SessionAccept
+	<-srv.frames
+
+	assert.Eventually(t, func() bool {
+		return len(tracer.ended(SpanHandle)) == 1 && len(tracer.ended(SpanWrite)) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	handle := tracer.ended(SpanHandle)[0]
+	assert.Equal(t, byte(5), handle.attrs[AttrService])
+	assert.Equal(t, byte(1), handle.attrs[AttrOrder])
+	assert.NotZero(t, handle.attrs[AttrSize])
+
+	write := tracer.ended(SpanWrite)[0]
+	assert.Equal(t, false, write.attrs[AttrControl])
+	dml := DMLMessage{ServiceID: 5, OrderNumber: 2, Packet: (&testMessage{Value: 2}).Marshal()}
+	assert.Equal(t, wireSize(4+len(dml.Marshal())+1), write.attrs[AttrSize])
+}
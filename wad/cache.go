@@ -0,0 +1,69 @@
+package wad
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// entryCache is an LRU cache of decompressed entry data bounded by total size in bytes
+type entryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type cacheItem struct {
+	path string
+	data []byte
+}
+
+func newEntryCache(maxBytes int64) *entryCache {
+	return &entryCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *entryCache) get(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[path]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return bytes.Clone(elem.Value.(*cacheItem).data), true
+}
+
+func (c *entryCache) add(path string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[path]; ok {
+		c.remove(elem)
+	}
+
+	for c.size+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+
+	c.items[path] = c.order.PushFront(&cacheItem{path, bytes.Clone(data)})
+	c.size += size
+}
+
+func (c *entryCache) remove(elem *list.Element) {
+	item := c.order.Remove(elem).(*cacheItem)
+	delete(c.items, item.path)
+	c.size -= int64(len(item.data))
+}
@@ -0,0 +1,106 @@
+package wad
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFileCache(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("a.txt", []byte("aaaaa"), false))
+	require.NoError(t, w.Add("b.txt", []byte("bbbbb"), false))
+	require.NoError(t, w.Close())
+
+	data := b.Bytes()
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), WithCache(5))
+	require.NoError(t, err)
+
+	contents, err := archive.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaa", string(contents))
+
+	// Overwrite the underlying data, a cached read should not notice
+	entry, _ := archive.EntryByPath("a.txt")
+	copy(data[entry.Offset:], "zzzzz")
+
+	contents, err = archive.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaa", string(contents))
+
+	// Reading b.txt evicts a.txt
+	_, err = archive.ReadFile("b.txt")
+	require.NoError(t, err)
+
+	contents, err = archive.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "zzzzz", string(contents))
+
+	_, err = archive.ReadFile("missing.txt")
+	assert.Equal(t, ErrEntryNotFound, err)
+}
+
+func TestReadFileCacheNormalizedPaths(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("a\\b.xml", []byte("aaaaa"), false))
+	require.NoError(t, w.Close())
+
+	data := b.Bytes()
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), WithCache(10), WithNormalizedPaths())
+	require.NoError(t, err)
+
+	contents, err := archive.ReadFile("a/b.xml")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaa", string(contents))
+
+	// Overwrite the underlying data, other spellings of the path should hit the cache
+	entry, _ := archive.EntryByPath("a/b.xml")
+	copy(data[entry.Offset:], "zzzzz")
+
+	for _, path := range []string{"a\\b.xml", "./a/b.xml", "a//b.xml"} {
+		contents, err := archive.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "aaaaa", string(contents), path)
+	}
+
+	assert.Len(t, archive.cache.items, 1)
+}
+
+func TestReadFileCacheConcurrent(t *testing.T) {
+	data := buildTestArchive(t, map[string]string{
+		"a.txt": "hello",
+		"b.txt": "world",
+		"c.txt": "again",
+	})
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), WithCache(10))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, path := range []string{"a.txt", "b.txt", "c.txt"}[i%3:] {
+				_, err := archive.ReadFile(path)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.LessOrEqual(t, archive.cache.size, int64(10))
+}
@@ -0,0 +1,74 @@
+package wad
+
+import (
+	"maps"
+	"slices"
+)
+
+type DiffKind int
+
+const (
+	DiffAdded DiffKind = iota + 1
+	DiffRemoved
+	DiffModified
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// DiffEntry is a path that differs between two archives. Old is the entry in the first archive
+// and is zero for added paths, New is the entry in the second and is zero for removed paths.
+type DiffEntry struct {
+	Path string
+	Kind DiffKind
+	Old  Entry
+	New  Entry
+}
+
+// Diff compares the entry tables of a and b and returns the paths that were added to, removed
+// from or modified in b, ordered by path. An entry is modified if its checksum or size changed.
+// No entry data is read. Where an archive contains a path more than once, the first entry is
+// compared, as with EntryByPath.
+func Diff(a, b *Archive) ([]DiffEntry, error) {
+	var diff []DiffEntry
+
+	paths := make(map[string]bool, len(a.index)+len(b.index))
+	for path := range a.index {
+		paths[path] = true
+	}
+	for path := range b.index {
+		paths[path] = true
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		oldEntry, inOld := a.EntryByPath(path)
+		newEntry, inNew := b.EntryByPath(path)
+
+		entry := DiffEntry{Path: path, Old: oldEntry, New: newEntry}
+
+		switch {
+		case !inOld:
+			entry.Kind = DiffAdded
+		case !inNew:
+			entry.Kind = DiffRemoved
+		case oldEntry.Checksum != newEntry.Checksum || oldEntry.Size != newEntry.Size:
+			entry.Kind = DiffModified
+		default:
+			continue
+		}
+
+		diff = append(diff, entry)
+	}
+
+	return diff, nil
+}
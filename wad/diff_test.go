@@ -0,0 +1,52 @@
+package wad
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	oldArchive, err := Open(writeTestArchive(t, map[string]string{
+		"same.txt":    "unchanged",
+		"changed.txt": "before",
+		"removed.txt": "gone",
+		"resized.txt": "short",
+	}))
+	require.NoError(t, err)
+	defer oldArchive.Close()
+
+	newArchive, err := Open(writeTestArchive(t, map[string]string{
+		"same.txt":    "unchanged",
+		"changed.txt": "after!",
+		"added.txt":   "new",
+		"resized.txt": "much longer",
+	}))
+	require.NoError(t, err)
+	defer newArchive.Close()
+
+	diff, err := Diff(oldArchive, newArchive)
+	require.NoError(t, err)
+
+	var (
+		paths []string
+		kinds []DiffKind
+	)
+	for _, entry := range diff {
+		paths = append(paths, entry.Path)
+		kinds = append(kinds, entry.Kind)
+	}
+
+	assert.Equal(t, []string{"added.txt", "changed.txt", "removed.txt", "resized.txt"}, paths)
+	assert.Equal(t, []DiffKind{DiffAdded, DiffModified, DiffRemoved, DiffModified}, kinds)
+
+	assert.Equal(t, Entry{}, diff[0].Old)
+	assert.Equal(t, "added.txt", diff[0].New.Path)
+	assert.Equal(t, Entry{}, diff[2].New)
+	assert.NotEqual(t, diff[1].Old.Checksum, diff[1].New.Checksum)
+
+	diff, err = Diff(oldArchive, oldArchive)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
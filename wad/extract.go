@@ -0,0 +1,91 @@
+package wad
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	progress func(done, total int, current string)
+}
+
+// WithProgress sets a callback invoked after each entry is processed, including entries that
+// were skipped or failed to extract.
+func WithProgress(progress func(done, total int, current string)) ExtractOption {
+	return func(o *extractOptions) {
+		o.progress = progress
+	}
+}
+
+// ExtractAll extracts every entry in the archive into destDir, creating directories as needed.
+// Entries with paths that would escape destDir are skipped.
+func (a *Archive) ExtractAll(destDir string, opts ...ExtractOption) error {
+	return a.ExtractFunc(destDir, func(Entry) bool { return true }, opts...)
+}
+
+// ExtractFunc is like ExtractAll, but only extracts the entries for which keep returns true. Other
+// entries aren't read, and aren't counted by the progress callback.
+func (a *Archive) ExtractFunc(destDir string, keep func(Entry) bool, opts ...ExtractOption) error {
+	var options extractOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var entries []Entry
+	for _, entry := range a.entries {
+		if keep(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	total := len(entries)
+
+	for i, entry := range entries {
+		err := a.extractEntry(destDir, entry)
+
+		if options.progress != nil {
+			options.progress(i+1, total, entry.Path)
+		}
+
+		if err != nil {
+			return fmt.Errorf("wad: error extracting %v: %w", entry.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *Archive) extractEntry(destDir string, entry Entry) error {
+	name, err := filepath.Localize(entry.Path)
+	if err != nil {
+		// Path is absolute or escapes destDir
+		return nil
+	}
+
+	path := filepath.Join(destDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	r, err := a.Entry(entry)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,84 @@
+package wad
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractAll(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"a.txt":       "hello",
+		"dir/b.txt":   "world",
+		"../evil.txt": "escaped",
+	})
+
+	archive, err := Open(path)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	var (
+		dest  = t.TempDir()
+		calls []int
+	)
+
+	progress := func(done, total int, current string) {
+		assert.Equal(t, 3, total)
+		calls = append(calls, done)
+	}
+
+	require.NoError(t, archive.ExtractAll(dest, WithProgress(progress)))
+	assert.Equal(t, []int{1, 2, 3}, calls)
+
+	data, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dest, "dir", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	_, err = os.Stat(filepath.Join(dest, "..", "evil.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractFunc(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"a.txt":     "hello",
+		"b.dat":     "skipped",
+		"dir/c.txt": "world",
+	})
+
+	archive, err := Open(path)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	var (
+		dest  = t.TempDir()
+		total int
+	)
+
+	keep := func(entry Entry) bool {
+		return filepath.Ext(entry.Path) == ".txt"
+	}
+	progress := func(_, n int, _ string) {
+		total = n
+	}
+
+	require.NoError(t, archive.ExtractFunc(dest, keep, WithProgress(progress)))
+	assert.Equal(t, 2, total)
+
+	data, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dest, "dir", "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	_, err = os.Stat(filepath.Join(dest, "b.dat"))
+	assert.True(t, os.IsNotExist(err))
+}
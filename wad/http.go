@@ -0,0 +1,162 @@
+package wad
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// httpBlockSize is the minimum number of bytes fetched per range request. Reading the entry
+// table issues many small reads, so they are served from the last fetched block where possible.
+const httpBlockSize = 64 * 1024
+
+// httpReaderAt implements io.ReaderAt over HTTP range requests
+type httpReaderAt struct {
+	client *http.Client
+	url    string
+	size   int64
+
+	mu         sync.Mutex
+	block      []byte
+	blockStart int64
+}
+
+// OpenHTTP opens a remote archive served by a server that supports range requests. Only the
+// header and entry table are fetched up front, entry data is fetched as it's read. ctx applies
+// to opening the archive, later reads aren't bound to it.
+func OpenHTTP(ctx context.Context, url string, opts ...OpenOption) (*Archive, error) {
+	r := &httpReaderAt{
+		client: http.DefaultClient,
+		url:    url,
+	}
+
+	size, err := r.fetchSize(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wad: error fetching archive size: %w", err)
+	}
+	r.size = size
+
+	// The header and entry table are fetched with ctx, entry data is fetched later with no
+	// deadline so that cancelling ctx after opening doesn't break the archive
+	archive, err := OpenReaderAt(readerAtContext{r, ctx}, size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	archive.r = r
+
+	return archive, nil
+}
+
+func (h *httpReaderAt) fetchSize(ctx context.Context) (int64, error) {
+	resp, err := h.request(ctx, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	// An empty file can't satisfy any range, its size is given as "bytes */0"
+	case http.StatusRequestedRangeNotSatisfiable:
+	default:
+		return 0, fmt.Errorf("expected status %v but got %v", http.StatusPartialContent, resp.StatusCode)
+	}
+
+	// Content-Range is of the form "bytes 0-0/size"
+	contentRange := resp.Header.Get("Content-Range")
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	return strconv.ParseInt(total, 10, 64)
+}
+
+func (h *httpReaderAt) request(ctx context.Context, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", start, end))
+
+	return h.client.Do(req)
+}
+
+func (h *httpReaderAt) get(ctx context.Context, start, end int64) (*http.Response, error) {
+	resp, err := h.request(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected status %v but got %v", http.StatusPartialContent, resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// readerAtContext reads from an httpReaderAt with ctx
+type readerAtContext struct {
+	h   *httpReaderAt
+	ctx context.Context
+}
+
+func (r readerAtContext) ReadAt(p []byte, off int64) (int, error) {
+	return r.h.readAt(r.ctx, p, off)
+}
+
+func (h *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return h.readAt(context.Background(), p, off)
+}
+
+func (h *httpReaderAt) readAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if off >= h.size {
+		return 0, io.EOF
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var (
+		end      = min(off+int64(len(p)), h.size)
+		blockEnd = h.blockStart + int64(len(h.block))
+	)
+
+	if h.block == nil || off < h.blockStart || end > blockEnd {
+		if err := h.fetchBlock(ctx, off, max(int64(len(p)), httpBlockSize)); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, h.block[off-h.blockStart:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (h *httpReaderAt) fetchBlock(ctx context.Context, off, length int64) error {
+	end := min(off+length, h.size) - 1
+
+	resp, err := h.get(ctx, off, end)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	block := make([]byte, end-off+1)
+	if _, err := io.ReadFull(resp.Body, block); err != nil {
+		return err
+	}
+
+	h.block = block
+	h.blockStart = off
+
+	return nil
+}
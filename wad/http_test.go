@@ -0,0 +1,103 @@
+package wad
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenHTTP(t *testing.T) {
+	large := bytes.Repeat([]byte("spiral"), 2*httpBlockSize)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("large.bin", large, false))
+	require.NoError(t, w.Add("a.txt", []byte("hello"), true))
+	require.NoError(t, w.Close())
+
+	var requests atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "test.wad", time.Time{}, bytes.NewReader(b.Bytes()))
+	}))
+	defer srv.Close()
+
+	archive, err := OpenHTTP(context.Background(), srv.URL)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	// One request for the size and one for the header and entry table
+	assert.Equal(t, int32(2), requests.Load())
+
+	data, err := archive.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	data, err = archive.ReadFile("large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, large, data)
+}
+
+func TestOpenHTTPNoRangeSupport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("KIWAD"))
+	}))
+	defer srv.Close()
+
+	_, err := OpenHTTP(context.Background(), srv.URL)
+	assert.Error(t, err)
+}
+
+func TestOpenHTTPContextCancelledAfterOpen(t *testing.T) {
+	// Large enough that reading it needs requests after the header block
+	large := bytes.Repeat([]byte("spiral"), httpBlockSize)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("large.bin", large, false))
+	require.NoError(t, w.Close())
+	data := b.Bytes()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.wad", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	archive, err := OpenHTTP(ctx, srv.URL)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	cancel()
+
+	contents, err := archive.ReadFile("large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, large, contents)
+}
+
+func TestOpenHTTPEmpty(t *testing.T) {
+	var ranges []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "test.wad", time.Time{}, bytes.NewReader(nil))
+	}))
+	defer srv.Close()
+
+	_, err := OpenHTTP(context.Background(), srv.URL)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "Content-Range")
+
+	// Only the size is requested, nothing is read from an empty archive
+	assert.Equal(t, []string{"bytes=0-0"}, ranges)
+}
@@ -0,0 +1,84 @@
+package wad
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// ArchiveSet is a merged view over several archives that form one namespace. When more than one
+// archive contains the same path, the archive opened last takes precedence.
+type ArchiveSet struct {
+	archives []*Archive
+	owners   map[string]int
+}
+
+// OpenSet opens each of the archives at paths and merges them into one set.
+func OpenSet(paths ...string) (*ArchiveSet, error) {
+	set := &ArchiveSet{
+		owners: make(map[string]int),
+	}
+
+	for _, path := range paths {
+		archive, err := Open(path)
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+
+		for entry := range archive.Entries() {
+			set.owners[entry.Path] = len(set.archives)
+		}
+		set.archives = append(set.archives, archive)
+	}
+
+	return set, nil
+}
+
+func (s *ArchiveSet) Close() error {
+	var errs []error
+
+	for _, archive := range s.archives {
+		if err := archive.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Entries yields every entry in the set. Entries overridden by a later archive are skipped.
+func (s *ArchiveSet) Entries() iter.Seq[Entry] {
+	return func(yield func(Entry) bool) {
+		for i, archive := range s.archives {
+			for entry := range archive.Entries() {
+				if s.owners[entry.Path] != i {
+					continue
+				}
+				if !yield(entry) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// EntryByPath returns the entry with the given path from the last archive that contains it.
+func (s *ArchiveSet) EntryByPath(path string) (Entry, bool) {
+	owner, ok := s.owners[path]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return s.archives[owner].EntryByPath(path)
+}
+
+// Entry returns a reader for the given entry from the archive that owns its path.
+func (s *ArchiveSet) Entry(entry Entry) (io.ReadCloser, error) {
+	owner, ok := s.owners[entry.Path]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+
+	return s.archives[owner].Entry(entry)
+}
@@ -0,0 +1,50 @@
+package wad
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSet(t *testing.T) {
+	first := writeTestArchive(t, map[string]string{
+		"a.txt":      "first",
+		"shared.txt": "old",
+	})
+	second := writeTestArchive(t, map[string]string{
+		"b.txt":      "second",
+		"shared.txt": "new",
+	})
+
+	set, err := OpenSet(first, second)
+	require.NoError(t, err)
+	defer set.Close()
+
+	var paths []string
+	for entry := range set.Entries() {
+		paths = append(paths, entry.Path)
+	}
+	assert.ElementsMatch(t, []string{"a.txt", "b.txt", "shared.txt"}, paths)
+
+	for path, expected := range map[string]string{
+		"a.txt":      "first",
+		"b.txt":      "second",
+		"shared.txt": "new",
+	} {
+		entry, ok := set.EntryByPath(path)
+		require.True(t, ok)
+
+		r, err := set.Entry(entry)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, expected, string(data))
+	}
+
+	_, ok := set.EntryByPath("missing.txt")
+	assert.False(t, ok)
+}
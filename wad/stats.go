@@ -0,0 +1,42 @@
+package wad
+
+// ArchiveStats summarises the entries in an archive.
+type ArchiveStats struct {
+	Entries    int
+	Compressed int
+	Stored     int
+	// Size is the total uncompressed size of every entry
+	Size uint64
+	// CompSize is the total size of every entry as it's held in the archive, stored entries
+	// count at their uncompressed size
+	CompSize uint64
+}
+
+// Ratio returns CompSize as a fraction of Size, or 1 if Size is zero.
+func (s ArchiveStats) Ratio() float64 {
+	if s.Size == 0 {
+		return 1
+	}
+
+	return float64(s.CompSize) / float64(s.Size)
+}
+
+// Stats returns a summary of the archive's entries. It doesn't read any entry data.
+func (a *Archive) Stats() ArchiveStats {
+	var stats ArchiveStats
+
+	for _, entry := range a.entries {
+		stats.Entries++
+		stats.Size += uint64(entry.Size)
+
+		if entry.Compressed {
+			stats.Compressed++
+			stats.CompSize += uint64(entry.CompSize)
+		} else {
+			stats.Stored++
+			stats.CompSize += uint64(entry.Size)
+		}
+	}
+
+	return stats
+}
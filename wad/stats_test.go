@@ -0,0 +1,37 @@
+package wad
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveStats(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("compressed.txt", bytes.Repeat([]byte("a"), 1000), true))
+	require.NoError(t, w.Add("stored.txt", []byte("hello"), false))
+	require.NoError(t, w.Close())
+
+	archive, err := OpenReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	require.NoError(t, err)
+
+	compressed, ok := archive.EntryByPath("compressed.txt")
+	require.True(t, ok)
+
+	stats := archive.Stats()
+	assert.Equal(t, ArchiveStats{
+		Entries:    2,
+		Compressed: 1,
+		Stored:     1,
+		Size:       1005,
+		CompSize:   uint64(compressed.CompSize) + 5,
+	}, stats)
+	assert.InDelta(t, float64(compressed.CompSize+5)/1005, stats.Ratio(), 1e-9)
+
+	assert.Equal(t, float64(1), ArchiveStats{}.Ratio())
+}
@@ -8,17 +8,64 @@ import (
 	"io"
 	"iter"
 	"os"
+	"path"
 	"strings"
 )
 
-var ErrMissingMagic = errors.New("missing WAD magic bytes")
+var (
+	ErrMissingMagic  = errors.New("missing WAD magic bytes")
+	ErrEntryNotFound = errors.New("wad: entry not found")
+)
 
 const magic = "KIWAD"
 
+// minEntrySize is the size of an entry in the entry table with an empty path
+const minEntrySize = 4 + 4 + 4 + 1 + 4 + 4
+
 type Archive struct {
-	file    *os.File
+	r       io.ReaderAt
 	header  header
 	entries []Entry
+	index   map[string]int
+	cache   *entryCache
+	dict    func(Entry) []byte
+
+	normalizePaths bool
+}
+
+type OpenOption func(*Archive)
+
+// WithDictionary sets a preset zlib dictionary used to inflate every compressed entry.
+func WithDictionary(dict []byte) OpenOption {
+	return WithDictionaryFunc(func(Entry) []byte {
+		return dict
+	})
+}
+
+// WithDictionaryFunc sets a function that returns the preset zlib dictionary for each compressed
+// entry. Entries for which it returns nil are inflated without a dictionary.
+func WithDictionaryFunc(dict func(Entry) []byte) OpenOption {
+	return func(a *Archive) {
+		a.dict = dict
+	}
+}
+
+// WithNormalizedPaths normalizes the path of every entry so that paths can be matched the same way
+// on every platform. Backslashes are replaced with forward slashes and the path is cleaned, so
+// "Data\GameData/./a.xml" becomes "Data/GameData/a.xml". The path as stored in the archive is
+// kept in Entry.RawPath. Paths given to EntryByPath and ReadFile are normalized the same way.
+func WithNormalizedPaths() OpenOption {
+	return func(a *Archive) {
+		a.normalizePaths = true
+	}
+}
+
+// WithCache caches the data of entries read with ReadFile, evicting the least recently used
+// entries once the cache holds more than maxBytes.
+func WithCache(maxBytes int64) OpenOption {
+	return func(a *Archive) {
+		a.cache = newEntryCache(maxBytes)
+	}
 }
 
 type header struct {
@@ -39,9 +86,12 @@ type Entry struct {
 	Compressed bool
 	Checksum   uint32
 	Path       string
+	// RawPath is the path as stored in the archive. It's the same as Path unless the archive was
+	// opened with WithNormalizedPaths.
+	RawPath string
 }
 
-func readEntry(r io.Reader) (Entry, error) {
+func readEntry(r io.Reader, remaining int64) (Entry, error) {
 	entry := Entry{}
 
 	if err := binary.Read(r, binary.LittleEndian, &entry.Offset); err != nil {
@@ -65,12 +115,17 @@ func readEntry(r io.Reader) (Entry, error) {
 		return entry, err
 	}
 
+	if int64(pathLen) > remaining {
+		return entry, fmt.Errorf("path length %v exceeds archive size", pathLen)
+	}
+
 	pathBuf := make([]byte, pathLen)
 	if _, err := io.ReadFull(r, pathBuf); err != nil {
 		return entry, err
 	}
 
 	entry.Path = strings.TrimRight(string(pathBuf), "\x00")
+	entry.RawPath = entry.Path
 	return entry, nil
 }
 
@@ -103,42 +158,106 @@ func readHeader(r io.Reader) (*header, error) {
 	return &h, nil
 }
 
-func Open(path string) (*Archive, error) {
+func Open(path string, opts ...OpenOption) (*Archive, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return OpenFile(file)
+	return OpenFile(file, opts...)
 }
 
-func OpenFile(file *os.File) (*Archive, error) {
-	header, err := readHeader(file)
+func OpenFile(file *os.File, opts ...OpenOption) (*Archive, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenReaderAt(file, info.Size(), opts...)
+}
+
+// OpenReaderAt opens an archive from r, which should contain size bytes. If r implements
+// io.Closer, it will be closed when the archive is closed.
+func OpenReaderAt(r io.ReaderAt, size int64, opts ...OpenOption) (*Archive, error) {
+	sr := io.NewSectionReader(r, 0, size)
+
+	header, err := readHeader(sr)
 	if err != nil {
 		return nil, fmt.Errorf("wad: error reading header: %w", err)
 	}
 
-	var entries []Entry
+	pos, err := sr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if int64(header.Count) > (size-pos)/minEntrySize {
+		return nil, fmt.Errorf("wad: entry count %v exceeds archive size", header.Count)
+	}
+
+	archive := &Archive{
+		r:       r,
+		header:  *header,
+		entries: make([]Entry, 0, header.Count),
+		index:   make(map[string]int),
+	}
+
+	for _, opt := range opts {
+		opt(archive)
+	}
 
 	for i := uint32(0); i < header.Count; i++ {
-		entry, err := readEntry(file)
+		pos, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := readEntry(sr, size-pos)
 		if err != nil {
 			return nil, fmt.Errorf("wad: error reading entry: %w", err)
 		}
-		entries = append(entries, entry)
+		if err := checkEntryBounds(entry, size); err != nil {
+			return nil, err
+		}
+		if archive.normalizePaths {
+			entry.Path = normalizePath(entry.Path)
+		}
+		if _, ok := archive.index[entry.Path]; !ok {
+			archive.index[entry.Path] = len(archive.entries)
+		}
+		archive.entries = append(archive.entries, entry)
 	}
 
-	archive := &Archive{
-		file:    file,
-		header:  *header,
-		entries: entries,
+	return archive, nil
+}
+
+// normalizePath replaces backslashes in p with forward slashes and cleans it
+func normalizePath(p string) string {
+	if p == "" {
+		return p
 	}
 
-	return archive, nil
+	return path.Clean(strings.ReplaceAll(p, "\\", "/"))
+}
+
+func checkEntryBounds(entry Entry, size int64) error {
+	length := entry.Size
+	if entry.Compressed {
+		length = entry.CompSize
+	}
+
+	if int64(entry.Offset)+int64(length) > size {
+		return fmt.Errorf("wad: entry %v with offset %v and length %v exceeds archive size %v", entry.Path, entry.Offset, length, size)
+	}
+
+	return nil
 }
 
 func (a *Archive) Close() error {
-	return a.file.Close()
+	if closer, ok := a.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
 }
 
 func (a *Archive) Entries() iter.Seq[Entry] {
@@ -151,8 +270,78 @@ func (a *Archive) Entries() iter.Seq[Entry] {
 	}
 }
 
-// Entry returns a reader for the given entry. The caller may only read one entry at a time.
-func (a *Archive) Entry(entry Entry) (io.Reader, error) {
+// EntryByPath returns the entry with the given path. If the archive contains the path more than
+// once, the first entry is returned.
+func (a *Archive) EntryByPath(path string) (Entry, bool) {
+	if a.normalizePaths {
+		path = normalizePath(path)
+	}
+
+	i, ok := a.index[path]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return a.entries[i], true
+}
+
+// ReadFile returns the data of the entry with the given path. If the archive was opened with
+// WithCache, the cache is consulted first. It is safe to call ReadFile concurrently.
+func (a *Archive) ReadFile(path string) ([]byte, error) {
+	entry, ok := a.EntryByPath(path)
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+
+	// Cache by the entry's path so that every spelling of a normalized path shares one item
+	if a.cache != nil {
+		if data, ok := a.cache.get(entry.Path); ok {
+			return data, nil
+		}
+	}
+
+	r, err := a.Entry(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cache != nil {
+		a.cache.add(entry.Path, data)
+	}
+
+	return data, nil
+}
+
+// CopyEntry writes the data of the entry with the given path to w without buffering it in
+// memory, returning the number of bytes written. It doesn't use the cache.
+func (a *Archive) CopyEntry(w io.Writer, path string) (int64, error) {
+	entry, ok := a.EntryByPath(path)
+	if !ok {
+		return 0, ErrEntryNotFound
+	}
+
+	r, err := a.Entry(entry)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	return io.Copy(w, r)
+}
+
+// Entry returns a reader for the given entry. The caller may only read one entry at a time and
+// must close the reader when done.
+//
+// Some archives mark entries as compressed when their data is stored raw. An entry is only
+// inflated if it is marked as compressed and its data starts with a zlib header, otherwise it is
+// read as stored.
+func (a *Archive) Entry(entry Entry) (io.ReadCloser, error) {
 	var (
 		offset   = int64(entry.Offset)
 		compSize = int64(entry.CompSize)
@@ -160,9 +349,37 @@ func (a *Archive) Entry(entry Entry) (io.Reader, error) {
 	)
 
 	if entry.Compressed {
-		section := io.NewSectionReader(a.file, offset, compSize)
-		return zlib.NewReader(section)
+		section := io.NewSectionReader(a.r, offset, compSize)
+
+		header := make([]byte, 2)
+		if _, err := section.ReadAt(header, 0); err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if hasZlibHeader(header) {
+			if a.dict != nil {
+				if dict := a.dict(entry); dict != nil {
+					return zlib.NewReaderDict(section, dict)
+				}
+			}
+
+			return zlib.NewReader(section)
+		}
+
+		// A mislabeled entry is read raw, but only CompSize was checked against the archive's
+		// bounds so Size can't be trusted
+		return io.NopCloser(section), nil
+	}
+
+	return io.NopCloser(io.NewSectionReader(a.r, offset, size)), nil
+}
+
+// hasZlibHeader reports whether b starts with a valid zlib header using the deflate method
+func hasZlibHeader(b []byte) bool {
+	if len(b) < 2 {
+		return false
 	}
 
-	return io.NewSectionReader(a.file, offset, size), nil
+	cmf, flg := b[0], b[1]
+	return cmf&0x0F == 8 && (uint16(cmf)<<8|uint16(flg))%31 == 0
 }
@@ -0,0 +1,289 @@
+package wad
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	data := buildTestArchive(t, map[string]string{
+		"a.txt": "hello",
+		"b.txt": "world",
+	})
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	defer archive.Close()
+
+	var contents []string
+	for entry := range archive.Entries() {
+		r, err := archive.Entry(entry)
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		contents = append(contents, string(b))
+	}
+
+	assert.Equal(t, []string{"hello", "world"}, contents)
+}
+
+func TestOpenReaderAtMissingMagic(t *testing.T) {
+	data := []byte("NOTAWAD")
+
+	_, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+	assert.True(t, errors.Is(err, ErrMissingMagic))
+}
+
+func TestOpenReaderAtBounds(t *testing.T) {
+	data := buildTestArchive(t, map[string]string{
+		"a.txt": "hello",
+	})
+
+	t.Run("count", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		// Entry count follows magic and version
+		binary.LittleEndian.PutUint32(corrupt[9:], math.MaxUint32)
+
+		_, err := OpenReaderAt(bytes.NewReader(corrupt), int64(len(corrupt)))
+		assert.Error(t, err)
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		// First entry offset follows the header
+		binary.LittleEndian.PutUint32(corrupt[14:], uint32(len(corrupt)))
+
+		_, err := OpenReaderAt(bytes.NewReader(corrupt), int64(len(corrupt)))
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := data[:len(data)-1]
+
+		_, err := OpenReaderAt(bytes.NewReader(truncated), int64(len(truncated)))
+		assert.Error(t, err)
+	})
+}
+
+func TestEntryMislabeledCompressed(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("a.txt", []byte("stored"), false))
+	require.NoError(t, w.Close())
+
+	data := b.Bytes()
+	// Compressed flag follows the offset, size and compressed size of the first entry
+	data[26] = 1
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	entry, ok := archive.EntryByPath("a.txt")
+	require.True(t, ok)
+	require.True(t, entry.Compressed)
+
+	r, err := archive.Entry(entry)
+	require.NoError(t, err)
+	defer r.Close()
+
+	contents, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "stored", string(contents))
+}
+
+func TestEntryMislabeledCompressedSize(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("a.txt", []byte("stored"), false))
+	require.NoError(t, w.Add("b.txt", []byte("secret"), false))
+	require.NoError(t, w.Close())
+
+	data := b.Bytes()
+	// Claim a size that runs into the next entry, only the compressed size is bounds checked
+	binary.LittleEndian.PutUint32(data[18:], 12)
+	data[26] = 1
+
+	archive, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	contents, err := archive.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "stored", string(contents))
+}
+
+// sparseReaderAt reads head at the start, data at dataOffset and zeros everywhere else
+type sparseReaderAt struct {
+	head       []byte
+	data       []byte
+	dataOffset int64
+}
+
+func (s sparseReaderAt) size() int64 {
+	return s.dataOffset + int64(len(s.data))
+}
+
+func (s sparseReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		pos := off + int64(i)
+
+		switch {
+		case pos >= s.size():
+			return i, io.EOF
+		case pos < int64(len(s.head)):
+			p[i] = s.head[pos]
+		case pos >= s.dataOffset:
+			p[i] = s.data[pos-s.dataOffset]
+		default:
+			p[i] = 0
+		}
+	}
+
+	return len(p), nil
+}
+
+func TestOpenReaderAtLargeOffset(t *testing.T) {
+	const (
+		path   = "big.txt"
+		offset = math.MaxUint32 - 64
+	)
+
+	data := []byte("far away")
+
+	var head bytes.Buffer
+	head.WriteString(magic)
+	binary.Write(&head, binary.LittleEndian, uint32(writerVersion))
+	binary.Write(&head, binary.LittleEndian, uint32(1))
+	head.WriteByte(0)
+	writeEntry(&head, Entry{
+		Offset:   offset,
+		Size:     uint32(len(data)),
+		CompSize: uint32(len(data)),
+		Path:     path,
+	})
+
+	r := sparseReaderAt{
+		head:       head.Bytes(),
+		data:       data,
+		dataOffset: offset,
+	}
+
+	archive, err := OpenReaderAt(r, r.size())
+	require.NoError(t, err)
+
+	entry, ok := archive.EntryByPath(path)
+	require.True(t, ok)
+	assert.Equal(t, uint32(offset), entry.Offset)
+
+	er, err := archive.Entry(entry)
+	require.NoError(t, err)
+	defer er.Close()
+
+	contents, err := io.ReadAll(er)
+	require.NoError(t, err)
+	assert.Equal(t, data, contents)
+}
+
+func TestEntryDictionary(t *testing.T) {
+	var (
+		dict     = []byte("wizard101 spiral wizard101 spiral")
+		contents = []byte("wizard101 spiral wizard101")
+	)
+
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevelDict(&compressed, zlib.BestCompression, dict)
+	require.NoError(t, err)
+	_, err = zw.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	entry := Entry{
+		Size:       uint32(len(contents)),
+		CompSize:   uint32(compressed.Len()),
+		Compressed: true,
+		Path:       "dict.txt",
+	}
+
+	var data bytes.Buffer
+	data.WriteString(magic)
+	binary.Write(&data, binary.LittleEndian, uint32(writerVersion))
+	binary.Write(&data, binary.LittleEndian, uint32(1))
+	data.WriteByte(0)
+	entry.Offset = uint32(data.Len() + entryTableSize(entry))
+	writeEntry(&data, entry)
+	data.Write(compressed.Bytes())
+
+	archive, err := OpenReaderAt(bytes.NewReader(data.Bytes()), int64(data.Len()), WithDictionary(dict))
+	require.NoError(t, err)
+
+	got, err := archive.ReadFile("dict.txt")
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+
+	// Without the dictionary the entry can't be inflated
+	archive, err = OpenReaderAt(bytes.NewReader(data.Bytes()), int64(data.Len()))
+	require.NoError(t, err)
+
+	_, err = archive.ReadFile("dict.txt")
+	assert.True(t, errors.Is(err, zlib.ErrDictionary))
+}
+
+func TestCopyEntry(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"a.txt": "hello",
+	})
+
+	archive, err := Open(path)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	var b bytes.Buffer
+	n, err := archive.CopyEntry(&b, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, "hello", b.String())
+
+	_, err = archive.CopyEntry(&b, "missing.txt")
+	assert.Equal(t, ErrEntryNotFound, err)
+}
+
+func TestWithNormalizedPaths(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		`Data\GameData/./a.xml`: "hello",
+	})
+
+	archive, err := Open(path, WithNormalizedPaths())
+	require.NoError(t, err)
+	defer archive.Close()
+
+	entry, ok := archive.EntryByPath("Data/GameData/a.xml")
+	require.True(t, ok)
+	assert.Equal(t, "Data/GameData/a.xml", entry.Path)
+	assert.Equal(t, `Data\GameData/./a.xml`, entry.RawPath)
+
+	data, err := archive.ReadFile(`Data\GameData\a.xml`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	raw, err := Open(path)
+	require.NoError(t, err)
+	defer raw.Close()
+
+	entry, ok = raw.EntryByPath(`Data\GameData/./a.xml`)
+	require.True(t, ok)
+	assert.Equal(t, entry.RawPath, entry.Path)
+}
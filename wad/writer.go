@@ -0,0 +1,162 @@
+package wad
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+const writerVersion = 2
+
+type WriterOption func(*Writer)
+
+// WithCompressionLevel sets the zlib level used for compressed entries. Any level accepted by
+// zlib.NewWriterLevel is valid.
+func WithCompressionLevel(level int) WriterOption {
+	return func(w *Writer) {
+		w.level = level
+	}
+}
+
+type writerEntry struct {
+	Entry
+	data []byte
+}
+
+// Writer builds a WAD archive. Entry data is held in memory until Close, when the header,
+// entry table and data are written out.
+type Writer struct {
+	w       io.Writer
+	level   int
+	entries []writerEntry
+}
+
+func NewWriter(w io.Writer, opts ...WriterOption) (*Writer, error) {
+	writer := &Writer{
+		w:     w,
+		level: zlib.DefaultCompression,
+	}
+
+	for _, opt := range opts {
+		opt(writer)
+	}
+
+	if writer.level < zlib.HuffmanOnly || writer.level > zlib.BestCompression {
+		return nil, fmt.Errorf("wad: invalid compression level %v", writer.level)
+	}
+
+	return writer, nil
+}
+
+// Add adds an entry to the archive, compressing the data if compress is set. Entries can't be
+// larger than 4GiB.
+func (w *Writer) Add(path string, data []byte, compress bool) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("wad: entry %v size %v exceeds the 32-bit entry table limit", path, len(data))
+	}
+
+	entry := writerEntry{
+		Entry: Entry{
+			Size:       uint32(len(data)),
+			CompSize:   uint32(len(data)),
+			Compressed: compress,
+			Checksum:   crc32.ChecksumIEEE(data),
+			Path:       path,
+		},
+		data: data,
+	}
+
+	if compress {
+		var b bytes.Buffer
+
+		zw, err := zlib.NewWriterLevel(&b, w.level)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		if uint64(b.Len()) > math.MaxUint32 {
+			return fmt.Errorf("wad: entry %v compressed size %v exceeds the 32-bit entry table limit", path, b.Len())
+		}
+
+		entry.CompSize = uint32(b.Len())
+		entry.data = b.Bytes()
+	}
+
+	w.entries = append(w.entries, entry)
+	return nil
+}
+
+// Close writes the archive to the underlying writer. It does not close the underlying writer.
+// The entry table stores offsets as 32-bit values, so it's an error for the archive to grow past
+// 4GiB.
+func (w *Writer) Close() error {
+	if end := w.layout(); end > math.MaxUint32 {
+		return fmt.Errorf("wad: archive size %v exceeds the 32-bit entry table limit", end)
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString(magic)
+	binary.Write(&b, binary.LittleEndian, uint32(writerVersion))
+	binary.Write(&b, binary.LittleEndian, uint32(len(w.entries)))
+	b.WriteByte(0)
+
+	for _, entry := range w.entries {
+		writeEntry(&b, entry.Entry)
+	}
+
+	if _, err := w.w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	for _, entry := range w.entries {
+		if _, err := w.w.Write(entry.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layout assigns entry offsets and returns the end of the archive.
+func (w *Writer) layout() uint64 {
+	// magic, version, count and flags byte
+	offset := uint64(len(magic) + 4 + 4 + 1)
+	for _, entry := range w.entries {
+		offset += uint64(entryTableSize(entry.Entry))
+	}
+
+	// Offsets past 4GiB are truncated, Close doesn't write the archive if there are any
+	for i := range w.entries {
+		w.entries[i].Offset = uint32(offset)
+		offset += uint64(w.entries[i].CompSize)
+	}
+
+	return offset
+}
+
+func entryTableSize(entry Entry) int {
+	// offset, size, compressed size, compressed flag, checksum, path length, path with NUL
+	return 4 + 4 + 4 + 1 + 4 + 4 + len(entry.Path) + 1
+}
+
+func writeEntry(b *bytes.Buffer, entry Entry) {
+	binary.Write(b, binary.LittleEndian, uint32(entry.Offset))
+	binary.Write(b, binary.LittleEndian, uint32(entry.Size))
+	binary.Write(b, binary.LittleEndian, uint32(entry.CompSize))
+	binary.Write(b, binary.LittleEndian, entry.Compressed)
+	binary.Write(b, binary.LittleEndian, entry.Checksum)
+	binary.Write(b, binary.LittleEndian, uint32(len(entry.Path)+1))
+	b.WriteString(entry.Path)
+	b.WriteByte(0)
+}
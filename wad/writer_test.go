@@ -0,0 +1,88 @@
+package wad
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTestArchive(t *testing.T, files map[string]string, opts ...WriterOption) []byte {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b, opts...)
+	require.NoError(t, err)
+
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		require.NoError(t, w.Add(name, []byte(files[name]), true))
+	}
+	require.NoError(t, w.Close())
+
+	return b.Bytes()
+}
+
+func writeTestArchive(t *testing.T, files map[string]string, opts ...WriterOption) string {
+	path := filepath.Join(t.TempDir(), "test.wad")
+	require.NoError(t, os.WriteFile(path, buildTestArchive(t, files, opts...), 0o644))
+
+	return path
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"a.txt": "hello",
+	}, WithCompressionLevel(zlib.BestSpeed))
+
+	archive, err := Open(path)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	var entries []Entry
+	for entry := range archive.Entries() {
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a.txt", entries[0].Path)
+	assert.True(t, entries[0].Compressed)
+
+	r, err := archive.Entry(entries[0])
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestWriterInvalidCompressionLevel(t *testing.T) {
+	_, err := NewWriter(io.Discard, WithCompressionLevel(10))
+	assert.Error(t, err)
+
+	_, err = NewWriter(io.Discard, WithCompressionLevel(-3))
+	assert.Error(t, err)
+}
+
+func TestWriterArchiveTooLarge(t *testing.T) {
+	var b bytes.Buffer
+
+	w, err := NewWriter(&b)
+	require.NoError(t, err)
+
+	// Adding 4GiB of data in a test isn't practical, so fake entries claiming to be that large
+	for _, path := range []string{"a.bin", "b.bin"} {
+		w.entries = append(w.entries, writerEntry{
+			Entry: Entry{Size: math.MaxUint32 / 2, CompSize: math.MaxUint32 / 2, Path: path},
+		})
+	}
+
+	assert.Error(t, w.Close())
+	assert.Zero(t, b.Len())
+}